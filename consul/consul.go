@@ -0,0 +1,148 @@
+// Package consul provides a GELF writer whose Graylog address is stored in
+// the Consul KV store.
+package consul
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	graylog "github.com/gemnasium/logrus-graylog-hook/v3"
+	"github.com/hashicorp/consul/api"
+)
+
+// retryDelay is the pause between two failed Consul queries.
+var retryDelay = 5 * time.Second
+
+// DynamicWriter implements the GELFWriter interface, sending messages to the
+// address read from a Consul key. The underlying writer is replaced each
+// time the key is updated.
+type DynamicWriter struct {
+	mu     sync.RWMutex
+	writer graylog.GELFWriter
+	addr   string
+
+	kv     *api.KV
+	key    string
+	opts   []graylog.WriterOption
+	cancel context.CancelFunc
+}
+
+// NewWriterFromConsul reads the Graylog address from key, builds a writer
+// for it with NewWriter and opts, and keeps watching the key for changes
+// using Consul blocking queries. Call Close to stop watching.
+func NewWriterFromConsul(client *api.Client, key string, opts ...graylog.WriterOption) (*DynamicWriter, error) {
+	kv := client.KV()
+	pair, meta, err := kv.Get(key, nil)
+	if err != nil {
+		return nil, err
+	}
+	if pair == nil {
+		return nil, fmt.Errorf("consul key %q not found", key)
+	}
+
+	w := &DynamicWriter{
+		kv:   kv,
+		key:  key,
+		opts: opts,
+	}
+	if err = w.setAddr(string(pair.Value)); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	go w.watch(ctx, meta.LastIndex)
+
+	return w, nil
+}
+
+// WriteMessage sends the message through the writer for the current address
+func (w *DynamicWriter) WriteMessage(m *graylog.Message) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.writer.WriteMessage(m)
+}
+
+// CurrentAddr returns the address messages are currently sent to
+func (w *DynamicWriter) CurrentAddr() string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.addr
+}
+
+// Close stops watching the Consul key, and closes the current writer
+func (w *DynamicWriter) Close() error {
+	w.cancel()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return closeWriter(w.writer)
+}
+
+func (w *DynamicWriter) setAddr(addr string) error {
+	addr = strings.TrimSpace(addr)
+	writer, err := graylog.NewWriter(addr, w.opts...)
+	if err != nil {
+		return fmt.Errorf("consul key %q: %s", w.key, err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	previous := w.writer
+	w.writer = writer
+	w.addr = addr
+	if err := closeWriter(previous); err != nil {
+		fmt.Println(err)
+	}
+	return nil
+}
+
+// closeWriter closes w if it holds resources, like sockets
+func closeWriter(w graylog.GELFWriter) error {
+	if c, ok := w.(graylog.GELFCloser); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// watch long-polls the Consul key until ctx is canceled
+func (w *DynamicWriter) watch(ctx context.Context, index uint64) {
+	for {
+		q := (&api.QueryOptions{WaitIndex: index}).WithContext(ctx)
+		pair, meta, err := w.kv.Get(w.key, q)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			fmt.Println(err)
+			select {
+			case <-time.After(retryDelay):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+
+		// the index is reset when going backwards, as advised by
+		// the Consul documentation on blocking queries
+		if meta.LastIndex < index {
+			index = 0
+		} else {
+			index = meta.LastIndex
+		}
+
+		if pair == nil || strings.TrimSpace(string(pair.Value)) == w.CurrentAddr() {
+			continue
+		}
+		if err := w.setAddr(string(pair.Value)); err != nil {
+			fmt.Println(err)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package consul
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	graylog "github.com/gemnasium/logrus-graylog-hook/v3"
+	"github.com/hashicorp/consul/api"
+)
+
+// fakeKV serves a single Consul key, supporting blocking queries
+type fakeKV struct {
+	mu      sync.Mutex
+	value   string
+	index   uint64
+	changed chan struct{}
+}
+
+func newFakeKV(value string) *fakeKV {
+	return &fakeKV{value: value, index: 1, changed: make(chan struct{})}
+}
+
+func (kv *fakeKV) set(value string) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	kv.value = value
+	kv.index++
+	close(kv.changed)
+	kv.changed = make(chan struct{})
+}
+
+func (kv *fakeKV) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	wait, _ := strconv.ParseUint(req.URL.Query().Get("index"), 10, 64)
+
+	kv.mu.Lock()
+	if wait != 0 && wait == kv.index {
+		changed := kv.changed
+		kv.mu.Unlock()
+		select {
+		case <-changed:
+		case <-req.Context().Done():
+			return
+		}
+		kv.mu.Lock()
+	}
+	value, index := kv.value, kv.index
+	kv.mu.Unlock()
+
+	rw.Header().Set("X-Consul-Index", strconv.FormatUint(index, 10))
+	json.NewEncoder(rw).Encode([]*api.KVPair{{Key: "graylog/addr", Value: []byte(value), ModifyIndex: index}})
+}
+
+func TestNewWriterFromConsul(t *testing.T) {
+	r1, err := graylog.NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	r2, err := graylog.NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+
+	kv := newFakeKV(r1.Addr())
+	server := httptest.NewServer(kv)
+	defer server.Close()
+
+	client, err := api.NewClient(&api.Config{Address: server.URL})
+	if err != nil {
+		t.Fatalf("api.NewClient: %s", err)
+	}
+
+	w, err := NewWriterFromConsul(client, "graylog/addr")
+	if err != nil {
+		t.Fatalf("NewWriterFromConsul: %s", err)
+	}
+	defer w.Close()
+
+	if w.CurrentAddr() != r1.Addr() {
+		t.Errorf("CurrentAddr: expected %s, got %s", r1.Addr(), w.CurrentAddr())
+	}
+	if err := w.WriteMessage(&graylog.Message{Version: "1.1", Short: "first"}); err != nil {
+		t.Fatalf("WriteMessage: %s", err)
+	}
+	if msg, err := r1.ReadMessage(); err != nil || msg.Short != "first" {
+		t.Errorf("ReadMessage: expected first message, got %v (%v)", msg, err)
+	}
+
+	kv.set(r2.Addr())
+	deadline := time.Now().Add(5 * time.Second)
+	for w.CurrentAddr() != r2.Addr() {
+		if time.Now().After(deadline) {
+			t.Fatalf("CurrentAddr: expected %s, got %s", r2.Addr(), w.CurrentAddr())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := w.WriteMessage(&graylog.Message{Version: "1.1", Short: "second"}); err != nil {
+		t.Fatalf("WriteMessage: %s", err)
+	}
+	if msg, err := r2.ReadMessage(); err != nil || msg.Short != "second" {
+		t.Errorf("ReadMessage: expected second message, got %v (%v)", msg, err)
+	}
+}
+
+// acceptOne returns a listener, and a channel closed once the connection
+// it accepts is closed by the client
+func acceptOne(t *testing.T) (net.Listener, chan struct{}) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %s", err)
+	}
+	closed := make(chan struct{})
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(ioutil.Discard, conn)
+		close(closed)
+	}()
+	return l, closed
+}
+
+func TestDynamicWriterClosesWriters(t *testing.T) {
+	var closed []chan struct{}
+	var addrs []string
+	for i := 0; i < 3; i++ {
+		l, c := acceptOne(t)
+		defer l.Close()
+		closed = append(closed, c)
+		addrs = append(addrs, "tcp://"+l.Addr().String())
+	}
+
+	kv := newFakeKV(addrs[0])
+	server := httptest.NewServer(kv)
+	defer server.Close()
+	client, err := api.NewClient(&api.Config{Address: server.URL})
+	if err != nil {
+		t.Fatalf("api.NewClient: %s", err)
+	}
+	w, err := NewWriterFromConsul(client, "graylog/addr")
+	if err != nil {
+		t.Fatalf("NewWriterFromConsul: %s", err)
+	}
+
+	waitClosed := func(i int) {
+		select {
+		case <-closed[i]:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("writer #%d was not closed", i)
+		}
+	}
+	for i := 1; i < 3; i++ {
+		kv.set(addrs[i])
+		waitClosed(i - 1)
+	}
+
+	select {
+	case <-closed[2]:
+		t.Fatal("the current writer should only be closed by Close")
+	default:
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	waitClosed(2)
+}
+
+func TestNewWriterFromConsulMissingKey(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	client, err := api.NewClient(&api.Config{Address: server.URL})
+	if err != nil {
+		t.Fatalf("api.NewClient: %s", err)
+	}
+
+	if _, err := NewWriterFromConsul(client, "graylog/addr"); err == nil {
+		t.Error("NewWriterFromConsul should fail when the key is missing")
+	}
+}
@@ -0,0 +1,133 @@
+package graylog
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultDeduplicationCacheSize is the number of distinct messages
+// tracked by the hooks with a DeduplicationWindow, unless set otherwise.
+const DefaultDeduplicationCacheSize = 1000
+
+// SuppressedCountKey is the field holding the number of repetitions of a
+// message suppressed by the DeduplicationWindow of the hook.
+const SuppressedCountKey = "_suppressed_count"
+
+// dedupCache tracks the messages sent less than window ago, keyed by level
+// and short message, to suppress their repetitions. Up to size messages
+// are tracked, the least recently repeated ones are evicted first.
+type dedupCache struct {
+	window time.Duration
+	size   int
+	flush  func(*Message) // sends the summary of a message repetitions
+
+	mu      sync.Mutex
+	lru     *list.List // of *dedupEntry, most recently repeated first
+	entries map[string]*list.Element
+}
+
+type dedupEntry struct {
+	key   string
+	last  *Message // the last repetition suppressed
+	count int
+	timer *time.Timer
+}
+
+func newDedupCache(window time.Duration, size int, flush func(*Message)) *dedupCache {
+	if size <= 0 {
+		size = DefaultDeduplicationCacheSize
+	}
+	return &dedupCache{
+		window:  window,
+		size:    size,
+		flush:   flush,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// suppress reports whether m repeats a message sent less than window ago,
+// counting the repetition in that case. Otherwise m must be sent, and its
+// repetitions are tracked until the window expires, when their count is
+// flushed.
+func (c *dedupCache) suppress(m *Message) bool {
+	key := fmt.Sprintf("%d\x00%s", m.Level, m.Short)
+
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*dedupEntry)
+		e.last = m
+		e.count++
+		c.lru.MoveToFront(el)
+		c.mu.Unlock()
+		return true
+	}
+
+	e := &dedupEntry{key: key}
+	c.entries[key] = c.lru.PushFront(e)
+	e.timer = time.AfterFunc(c.window, func() { c.expire(e) })
+	var evicted *Message
+	if c.lru.Len() > c.size {
+		oldest := c.lru.Back().Value.(*dedupEntry)
+		oldest.timer.Stop()
+		evicted = c.remove(oldest)
+	}
+	c.mu.Unlock()
+
+	if evicted != nil {
+		c.flush(evicted)
+	}
+	return false
+}
+
+// expire flushes e once its window is over, unless it was evicted first.
+func (c *dedupCache) expire(e *dedupEntry) {
+	c.mu.Lock()
+	var m *Message
+	if el, ok := c.entries[e.key]; ok && el.Value == e {
+		m = c.remove(e)
+	}
+	c.mu.Unlock()
+
+	if m != nil {
+		c.flush(m)
+	}
+}
+
+// flushAll flushes all the tracked messages, without waiting for their
+// window to expire.
+func (c *dedupCache) flushAll() {
+	c.mu.Lock()
+	var summaries []*Message
+	for el := c.lru.Back(); el != nil; el = c.lru.Back() {
+		e := el.Value.(*dedupEntry)
+		e.timer.Stop()
+		if m := c.remove(e); m != nil {
+			summaries = append(summaries, m)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, m := range summaries {
+		c.flush(m)
+	}
+}
+
+// remove stops tracking e, and returns the summary of its repetitions to
+// flush, or nil if there were none. c.mu must be held.
+func (c *dedupCache) remove(e *dedupEntry) *Message {
+	c.lru.Remove(c.entries[e.key])
+	delete(c.entries, e.key)
+	if e.count == 0 {
+		return nil
+	}
+
+	m := e.last
+	if m.Extra == nil {
+		m.Extra = map[string]interface{}{}
+	}
+	m.Extra[SuppressedCountKey] = e.count
+	return m
+}
@@ -0,0 +1,84 @@
+package graylog
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestDedupCache(t *testing.T) {
+	var flushed []*Message
+	c := newDedupCache(time.Hour, 2, func(m *Message) { flushed = append(flushed, m) })
+
+	steps := []struct {
+		short    string
+		level    int32
+		suppress bool
+	}{
+		{"timeout", SyslogError, false},
+		{"timeout", SyslogError, true},
+		{"timeout", SyslogWarning, false},
+		{"timeout", SyslogError, true},
+		{"refused", SyslogError, false}, // evicts the warning, never repeated
+		{"other", SyslogError, false},   // evicts the error, repeated twice
+	}
+	for i, s := range steps {
+		if got := c.suppress(&Message{Short: s.short, Level: s.level}); got != s.suppress {
+			t.Errorf("#%d: expected suppress %t, got %t", i, s.suppress, got)
+		}
+	}
+
+	if len(flushed) != 1 || flushed[0].Short != "timeout" || flushed[0].Extra[SuppressedCountKey] != 2 {
+		t.Fatalf("expected the timeout error to be flushed with a count of 2, got %v", flushed)
+	}
+	if len(c.entries) != 2 || c.lru.Len() != 2 {
+		t.Errorf("expected 2 messages to be tracked, got %d", len(c.entries))
+	}
+	c.flushAll()
+	if len(flushed) != 1 || len(c.entries) != 0 {
+		t.Errorf("expected nothing more to flush, got %d messages and %d tracked", len(flushed), len(c.entries))
+	}
+}
+
+func TestDeduplicationWindow(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+	hook := NewGraylogHook(r.Addr(), nil)
+	hook.DeduplicationWindow = 50 * time.Millisecond
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	logger.Hooks.Add(hook)
+	for i := 0; i < 4; i++ {
+		logger.WithField("attempt", i).Error("connection refused")
+	}
+	logger.Warn("connection refused")
+
+	expected := []struct {
+		level   int32
+		attempt json.Number
+		count   interface{}
+	}{
+		{SyslogError, "0", nil},
+		{SyslogWarning, "", nil},
+		{SyslogError, "3", json.Number("3")},
+	}
+	for _, e := range expected {
+		msg, err := r.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage: %s", err)
+		}
+		if msg.Level != e.level || msg.Extra[SuppressedCountKey] != e.count {
+			t.Errorf("expected level %d with count %v, got level %d with %v", e.level, e.count, msg.Level, msg.Extra[SuppressedCountKey])
+		}
+		if e.attempt != "" && msg.Extra["_attempt"] != e.attempt {
+			t.Errorf("expected attempt %s, got %v", e.attempt, msg.Extra["_attempt"])
+		}
+	}
+}
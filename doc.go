@@ -0,0 +1,19 @@
+// Package graylog provides a logrus hook and writers sending messages to a
+// Graylog server in the GELF format.
+//
+// # Version negotiation
+//
+// UDPWriter.NegotiateVersion is an opt-in handshake for deployments running
+// a GELF echo service in front of Graylog. The writer sends a single
+// uncompressed, unchunked GELF message flagged with a "_probe" field:
+//
+//	{"version":"1.1","host":"<hostname>","short_message":"GELF version probe",...,"_probe":true}
+//
+// The echo service is expected to answer on the same socket with a JSON
+// object advertising the version it accepts:
+//
+//	{"version":"1.1"}
+//
+// Any other field of the answer is ignored. Without an answer before the
+// context is done, the writer assumes version "1.1".
+package graylog
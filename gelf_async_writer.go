@@ -0,0 +1,106 @@
+package graylog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+var (
+	// ErrQueueFull is returned by AsyncWriter.WriteMessage when the
+	// message is dropped because the queue is full.
+	ErrQueueFull = errors.New("async writer queue is full")
+	// ErrWriterClosed is returned when writing to a closed writer.
+	ErrWriterClosed = errors.New("writer is closed")
+)
+
+// AsyncWriter implements the GELFWriter interface, queuing messages to be
+// sent to another GELFWriter by a background goroutine, so that logging
+// doesn't wait for the network. Write errors are printed, as there is no
+// caller left to return them to. Call Close before exiting to send the
+// queued messages.
+type AsyncWriter struct {
+	// BlockOnFull makes WriteMessage wait for room in the queue, instead of
+	// dropping the message.
+	BlockOnFull bool
+
+	writer GELFWriter
+	queue  chan *Message
+	wg     sync.WaitGroup // queued messages
+	done   chan struct{}  // closed when the background goroutine exits
+	mu     sync.RWMutex   // held to close the queue
+	closed bool
+}
+
+// NewAsyncWriter wraps w, queuing up to queueSize messages.
+func NewAsyncWriter(w GELFWriter, queueSize int) *AsyncWriter {
+	aw := &AsyncWriter{
+		writer: w,
+		queue:  make(chan *Message, queueSize),
+		done:   make(chan struct{}),
+	}
+	go aw.drain()
+	return aw
+}
+
+// WriteMessage queues a copy of the message, see Message.Clone, so that the
+// caller can modify or reuse it. When the queue is full, the message is
+// dropped and ErrQueueFull returned, unless BlockOnFull is set.
+func (w *AsyncWriter) WriteMessage(m *Message) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		return ErrWriterClosed
+	}
+
+	m = m.Clone()
+	w.wg.Add(1)
+	if w.BlockOnFull {
+		w.queue <- m
+		return nil
+	}
+	select {
+	case w.queue <- m:
+		return nil
+	default:
+		w.wg.Done()
+		return ErrQueueFull
+	}
+}
+
+// Flush waits for the queued messages to be sent.
+func (w *AsyncWriter) Flush() error {
+	w.wg.Wait()
+	return nil
+}
+
+// Close sends the queued messages, stops the background goroutine, and
+// closes the wrapped writer if it has a Close method.
+func (w *AsyncWriter) Close() error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+
+	<-w.done
+	if c, ok := w.writer.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// drain sends the queued messages until the queue is closed
+func (w *AsyncWriter) drain() {
+	defer close(w.done)
+
+	for m := range w.queue {
+		if err := w.writer.WriteMessage(m); err != nil {
+			fmt.Println(err)
+		}
+		w.wg.Done()
+	}
+}
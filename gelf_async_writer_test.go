@@ -0,0 +1,85 @@
+package graylog
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestAsyncWriter(t *testing.T) {
+	dest := &blockingWriter{release: make(chan struct{})}
+	w := NewAsyncWriter(dest, 1)
+
+	// the first message is being sent, the second one is queued
+	if err := w.WriteMessage(Logf(SyslogInformational, "sending")); err != nil {
+		t.Fatalf("WriteMessage: %s", err)
+	}
+	var err error
+	for err == nil {
+		err = w.WriteMessage(Logf(SyslogInformational, "queued"))
+	}
+	if err != ErrQueueFull {
+		t.Errorf("WriteMessage: expected %v, got %v", ErrQueueFull, err)
+	}
+
+	close(dest.release)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	if err := w.WriteMessage(Logf(SyslogInformational, "closed")); err != ErrWriterClosed {
+		t.Errorf("WriteMessage: expected %v, got %v", ErrWriterClosed, err)
+	}
+}
+
+func TestAsyncWriterBlockOnFull(t *testing.T) {
+	dest := &flakyWriter{}
+	w := NewAsyncWriter(dest, 1)
+	w.BlockOnFull = true
+
+	expected := []string{"one", "two", "three", "four"}
+	for _, short := range expected {
+		if err := w.WriteMessage(Logf(SyslogInformational, short)); err != nil {
+			t.Fatalf("WriteMessage: %s", err)
+		}
+	}
+	w.Flush()
+	if got := dest.messages(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("messages: expected %v, got %v", expected, got)
+	}
+	w.Close()
+}
+
+func TestAsyncWriterClonesMessages(t *testing.T) {
+	dest := &flakyWriter{}
+	w := NewAsyncWriter(dest, 16)
+	w.BlockOnFull = true
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m := Logf(SyslogInformational, "").AppendExtra("attempt", 0)
+			for attempt := 1; attempt <= 10; attempt++ {
+				m.Short = "queued"
+				if err := w.WriteMessage(m); err != nil {
+					t.Errorf("WriteMessage: %s", err)
+				}
+				// modified while queued: -race reports it without the copy
+				m.Short = "modified"
+				m.Extra["_attempt"] = attempt
+			}
+		}()
+	}
+	wg.Wait()
+	w.Close()
+
+	for _, short := range dest.messages() {
+		if short != "queued" {
+			t.Fatalf("expected the queued messages to be copies, got %q", short)
+		}
+	}
+	if n := len(dest.messages()); n != 40 {
+		t.Errorf("expected 40 messages, got %d", n)
+	}
+}
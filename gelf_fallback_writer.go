@@ -0,0 +1,97 @@
+package graylog
+
+import (
+	"sync"
+	"time"
+)
+
+// FallbackWriter implements the GELFWriter interface, sending each message
+// to a primary writer, and to a secondary one when the primary fails.
+// After FallbackThreshold consecutive failures, the primary writer is
+// bypassed until a health check probing it every HealthCheckInterval
+// succeeds. Unlike WriterGroup, the primary writer is always the preferred
+// one.
+type FallbackWriter struct {
+	// OnFallback is called with the primary writer error each time a
+	// message is sent to the secondary writer because of it. It must not
+	// write to the FallbackWriter.
+	OnFallback func(primary error)
+	// FallbackThreshold is the number of consecutive primary failures
+	// after which it is bypassed, 0 to always try it first.
+	FallbackThreshold   int
+	HealthCheckInterval time.Duration
+
+	mu        sync.Mutex
+	primary   GELFWriter
+	secondary GELFWriter
+	failures  int
+	bypassed  bool
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewFallbackWriter returns a writer sending to primary, or to secondary
+// when primary fails.
+func NewFallbackWriter(primary, secondary GELFWriter) *FallbackWriter {
+	return &FallbackWriter{
+		HealthCheckInterval: 5 * time.Second,
+		primary:             primary,
+		secondary:           secondary,
+		stop:                make(chan struct{}),
+	}
+}
+
+// WriteMessage sends m to the primary writer, or to the secondary one if
+// the primary fails or is bypassed.
+func (w *FallbackWriter) WriteMessage(m *Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.bypassed {
+		err := w.primary.WriteMessage(m)
+		if err == nil {
+			w.failures = 0
+			return nil
+		}
+
+		w.failures++
+		if w.FallbackThreshold > 0 && w.failures >= w.FallbackThreshold {
+			w.bypassed = true
+			go w.healthCheck()
+		}
+		if w.OnFallback != nil {
+			w.OnFallback(err)
+		}
+	}
+	return w.secondary.WriteMessage(m)
+}
+
+// Bypassed returns whether the primary writer is bypassed, waiting for a
+// successful health check.
+func (w *FallbackWriter) Bypassed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.bypassed
+}
+
+// Close stops the health check, if any
+func (w *FallbackWriter) Close() error {
+	w.closeOnce.Do(func() {
+		close(w.stop)
+	})
+	return nil
+}
+
+// healthCheck probes the primary writer until a write succeeds, and then
+// stops bypassing it.
+func (w *FallbackWriter) healthCheck() {
+	if probeUntilHealthy(w.primary, w.HealthCheckInterval, w.stop, func() *Message {
+		return Logf(SyslogDebug, "graylog writer health check").AppendExtra("_health_check", true)
+	}) {
+		w.mu.Lock()
+		w.bypassed = false
+		w.failures = 0
+		w.mu.Unlock()
+	}
+}
@@ -0,0 +1,67 @@
+package graylog
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFallbackWriter(t *testing.T) {
+	primary := &flakyWriter{failures: 2}
+	secondary := &flakyWriter{}
+	w := NewFallbackWriter(primary, secondary)
+	defer w.Close()
+
+	var fallbacks int
+	w.OnFallback = func(error) { fallbacks++ }
+
+	for _, short := range []string{"one", "two", "three"} {
+		if err := w.WriteMessage(Logf(SyslogInformational, short)); err != nil {
+			t.Fatalf("WriteMessage: %s", err)
+		}
+	}
+	if got := secondary.messages(); !reflect.DeepEqual(got, []string{"one", "two"}) {
+		t.Errorf("secondary: expected one and two, got %v", got)
+	}
+	if got := primary.messages(); !reflect.DeepEqual(got, []string{"three"}) {
+		t.Errorf("primary: expected three, got %v", got)
+	}
+	if fallbacks != 2 {
+		t.Errorf("OnFallback: expected 2 calls, got %d", fallbacks)
+	}
+	if w.Bypassed() {
+		t.Error("the primary writer should not be bypassed without FallbackThreshold")
+	}
+}
+
+func TestFallbackWriterThreshold(t *testing.T) {
+	primary := &flakyWriter{failures: 3}
+	secondary := &flakyWriter{}
+	w := NewFallbackWriter(primary, secondary)
+	w.FallbackThreshold = 2
+	w.HealthCheckInterval = 5 * time.Millisecond
+	defer w.Close()
+
+	w.WriteMessage(Logf(SyslogInformational, "one"))
+	w.WriteMessage(Logf(SyslogInformational, "two"))
+	if !w.Bypassed() {
+		t.Fatal("the primary writer should be bypassed after 2 failures")
+	}
+	w.WriteMessage(Logf(SyslogInformational, "three"))
+	if got := secondary.messages(); !reflect.DeepEqual(got, []string{"one", "two", "three"}) {
+		t.Errorf("secondary: expected all the messages, got %v", got)
+	}
+
+	// the first health check fails, the second one succeeds
+	deadline := time.Now().Add(time.Second)
+	for w.Bypassed() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if w.Bypassed() {
+		t.Fatal("the primary writer should be used again after a successful health check")
+	}
+	w.WriteMessage(Logf(SyslogInformational, "four"))
+	if got := primary.messages(); !reflect.DeepEqual(got, []string{"graylog writer health check", "four"}) {
+		t.Errorf("primary: expected the health check and four, got %v", got)
+	}
+}
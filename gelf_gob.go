@@ -0,0 +1,46 @@
+package graylog
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"time"
+)
+
+func init() {
+	// types found in Extra once a message is decoded from JSON, or set
+	// by this package
+	RegisterExtraType(map[string]interface{}{})
+	RegisterExtraType([]interface{}{})
+	RegisterExtraType(json.Number(""))
+	RegisterExtraType(time.Time{})
+	RegisterExtraType(time.Duration(0))
+}
+
+// RegisterExtraType records the concrete type of v so that Extra values of
+// this type survive ToGob and FromGob. Basic types (strings, numbers,
+// booleans...) don't need to be registered. Like gob.Register, it panics if
+// the type is registered twice under different names.
+func RegisterExtraType(v interface{}) {
+	gob.Register(v)
+}
+
+// ToGob encodes the message with encoding/gob, a cheaper format than JSON
+// to store messages in a cache.
+func (m *Message) ToGob() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(m); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// FromGob decodes a message encoded by ToGob, replacing the content of m.
+func (m *Message) FromGob(data []byte) error {
+	var decoded Message
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&decoded); err != nil {
+		return err
+	}
+	*m = decoded
+	return nil
+}
@@ -0,0 +1,49 @@
+package graylog
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type gobPoint struct {
+	X, Y int
+}
+
+func TestGobRoundTrip(t *testing.T) {
+	RegisterExtraType(gobPoint{})
+
+	m := Logf(4, "cache me").
+		AppendExtra("user", "alice").
+		AppendExtra("count", 3).
+		AppendExtra("ratio", 0.5).
+		AppendExtra("ok", true).
+		AppendExtra("at", time.Unix(1577836800, 0).UTC()).
+		AppendExtra("tags", []interface{}{"a", "b"}).
+		AppendExtra("point", gobPoint{1, 2})
+	m.Full = "full message"
+	m.File = "main.go"
+	m.Line = 12
+
+	data, err := m.ToGob()
+	if err != nil {
+		t.Fatalf("ToGob: %s", err)
+	}
+
+	decoded := &Message{Short: "overwritten", Extra: map[string]interface{}{"_stale": 1}}
+	if err := decoded.FromGob(data); err != nil {
+		t.Fatalf("FromGob: %s", err)
+	}
+	if !reflect.DeepEqual(m, decoded) {
+		t.Errorf("gob round trip: expected %#v, got %#v", m, decoded)
+	}
+}
+
+func TestGobUnregisteredType(t *testing.T) {
+	type unregistered struct{ A int }
+
+	m := Logf(SyslogInfoLevel, "test").AppendExtra("value", unregistered{1})
+	if _, err := m.ToGob(); err == nil {
+		t.Error("ToGob should fail on unregistered extra types")
+	}
+}
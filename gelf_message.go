@@ -0,0 +1,419 @@
+package graylog
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Syslog severity levels, used as GELF message levels
+const (
+	SyslogEmergency     int32 = 0
+	SyslogAlert         int32 = 1
+	SyslogCritical      int32 = 2
+	SyslogError         int32 = 3
+	SyslogWarning       int32 = 4
+	SyslogNotice        int32 = 5
+	SyslogInformational int32 = 6
+	SyslogDebug         int32 = 7
+)
+
+var (
+	hostnameOnce sync.Once
+	hostname     string
+)
+
+// cachedHostname returns os.Hostname(), looked up once per process.
+func cachedHostname() string {
+	hostnameOnce.Do(func() {
+		var err error
+		if hostname, err = os.Hostname(); err != nil {
+			hostname = "localhost"
+		}
+	})
+	return hostname
+}
+
+// Logf builds a GELF 1.1 message for the current host and time, with a
+// short message formatted like fmt.Sprintf.
+func Logf(level int32, format string, args ...interface{}) *Message {
+	return &Message{
+		Version:  GELFVersion,
+		Host:     cachedHostname(),
+		Short:    fmt.Sprintf(format, args...),
+		TimeUnix: float64(time.Now().UnixNano()/1000000) / 1000.,
+		Level:    level,
+		Extra:    map[string]interface{}{},
+	}
+}
+
+// Clone returns a copy of m which can be modified independently, with its
+// own Extra map. The Extra values themselves are not copied.
+func (m *Message) Clone() *Message {
+	c := *m
+	if m.Extra != nil {
+		c.Extra = make(map[string]interface{}, len(m.Extra))
+		for k, v := range m.Extra {
+			c.Extra[k] = v
+		}
+	}
+	return &c
+}
+
+// AppendExtra sets an additional field on the message and returns it.
+// The GELF "_" prefix is added to key when missing.
+func (m *Message) AppendExtra(key string, value interface{}) *Message {
+	if !strings.HasPrefix(key, "_") {
+		key = "_" + key
+	}
+	if m.Extra == nil {
+		m.Extra = make(map[string]interface{}, 1)
+	}
+	m.Extra[key] = value
+	return m
+}
+
+// SetLevelFromHTTPStatus sets the _http_status_code field, and the level
+// from the class of code: debug for 1xx, informational for 2xx, notice for
+// 3xx, warning for 4xx and error for 5xx. The level is left unchanged for
+// codes out of these classes.
+func (m *Message) SetLevelFromHTTPStatus(code int) *Message {
+	switch {
+	case code >= 100 && code < 200:
+		m.Level = SyslogDebug
+	case code >= 200 && code < 300:
+		m.Level = SyslogInformational
+	case code >= 300 && code < 400:
+		m.Level = SyslogNotice
+	case code >= 400 && code < 500:
+		m.Level = SyslogWarning
+	case code >= 500 && code < 600:
+		m.Level = SyslogError
+	}
+	return m.AppendExtra("_http_status_code", code)
+}
+
+// WithResponseTime sets d as _response_time_ms, in whole milliseconds.
+func (m *Message) WithResponseTime(d time.Duration) *Message {
+	return m.AppendExtra("_response_time_ms", d.Milliseconds())
+}
+
+// WithResponseTimeNs sets d as _response_time_ns, in nanoseconds.
+func (m *Message) WithResponseTimeNs(d time.Duration) *Message {
+	return m.AppendExtra("_response_time_ns", d.Nanoseconds())
+}
+
+// WithKubernetesMetadata sets the pod, namespace, container and node names
+// as the _k8s_pod_name, _k8s_namespace, _k8s_container_name and
+// _k8s_node_name fields.
+func (m *Message) WithKubernetesMetadata(pod, namespace, container, node string) *Message {
+	return m.AppendExtra("_k8s_pod_name", pod).
+		AppendExtra("_k8s_namespace", namespace).
+		AppendExtra("_k8s_container_name", container).
+		AppendExtra("_k8s_node_name", node)
+}
+
+// WithKubernetesMetadataFromEnv is WithKubernetesMetadata with the
+// POD_NAME, POD_NAMESPACE, CONTAINER_NAME and NODE_NAME environment
+// variables, usually set with the downward API. Missing variables are sent
+// as empty strings.
+func (m *Message) WithKubernetesMetadataFromEnv() *Message {
+	return m.WithKubernetesMetadata(os.Getenv("POD_NAME"), os.Getenv("POD_NAMESPACE"),
+		os.Getenv("CONTAINER_NAME"), os.Getenv("NODE_NAME"))
+}
+
+// WithGoroutineID sets the id of the calling goroutine as _goroutine_id.
+// The id is parsed from runtime.Stack, which costs around 500ns, and up to
+// a few microseconds on a busy machine: use it for debug messages only.
+func (m *Message) WithGoroutineID() *Message {
+	return m.AppendExtra("_goroutine_id", goroutineID())
+}
+
+// goroutineID parses the first line of the stack trace, "goroutine N [...".
+// It returns 0 if the line can't be parsed.
+func goroutineID() int64 {
+	var buf [64]byte
+	line := buf[:runtime.Stack(buf[:], false)]
+	line = bytes.TrimPrefix(line, []byte("goroutine "))
+	if i := bytes.IndexByte(line, ' '); i > 0 {
+		line = line[:i]
+	}
+	id, _ := strconv.ParseInt(string(line), 10, 64)
+	return id
+}
+
+// SanitizeHost turns Host into an RFC 1123 compliant hostname: lowercase
+// letters, digits, hyphens and dots only, at most 255 bytes long.
+func (m *Message) SanitizeHost() *Message {
+	m.Host = sanitizeHostname(m.Host)
+	return m
+}
+
+// Flatten returns a new map with the named fields, under their GELF names,
+// and the additional fields, for template engines or other serialization
+// formats.
+func (m *Message) Flatten() map[string]interface{} {
+	flat := make(map[string]interface{}, len(m.Extra)+9)
+	for k, v := range m.Extra {
+		flat[k] = v
+	}
+	flat["version"] = m.Version
+	flat["host"] = m.Host
+	flat["short_message"] = m.Short
+	flat["full_message"] = m.Full
+	flat["timestamp"] = m.TimeUnix
+	flat["level"] = m.Level
+	flat["facility"] = m.Facility
+	flat["file"] = m.File
+	flat["line"] = m.Line
+	return flat
+}
+
+// ToLogrusFields returns the message as logrus fields: the named fields
+// that are set, under their GELF names, and the additional fields, under
+// their "_" prefixed names. The level is converted to a logrus.Level.
+func (m *Message) ToLogrusFields() logrus.Fields {
+	fields := make(logrus.Fields, len(m.Extra)+9)
+	for k, v := range m.Extra {
+		fields[k] = v
+	}
+
+	fields["level"] = syslogLevelToLogrus(m.Level)
+	for k, v := range map[string]string{
+		"version":       m.Version,
+		"host":          m.Host,
+		"short_message": m.Short,
+		"full_message":  m.Full,
+		"facility":      m.Facility,
+		"file":          m.File,
+	} {
+		if v != "" {
+			fields[k] = v
+		}
+	}
+	if m.TimeUnix != 0 {
+		fields["timestamp"] = m.TimeUnix
+	}
+	if m.Line != 0 {
+		fields["line"] = m.Line
+	}
+	return fields
+}
+
+// WithErrorChain describes err and the errors it wraps. The short message is
+// set to err itself, the full message to the whole chain, one "caused by"
+// line per wrapped error, and _error_chain_depth to the length of the
+// chain. If err has a Code() int method, its code is set as _error_code.
+func (m *Message) WithErrorChain(err error) *Message {
+	if err == nil {
+		return m
+	}
+
+	var full strings.Builder
+	depth := 0
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if depth > 0 {
+			full.WriteString("\n  caused by: ")
+		}
+		full.WriteString(e.Error())
+		depth++
+	}
+
+	m.Short = err.Error()
+	m.Full = full.String()
+	m.AppendExtra("_error_chain_depth", depth)
+	if coder, ok := err.(interface{ Code() int }); ok {
+		m.AppendExtra("_error_code", coder.Code())
+	}
+	return m
+}
+
+// Redact removes the given additional fields from the message and returns it.
+// Like for AppendExtra, the GELF "_" prefix is added to the keys when
+// missing. The fields set without the prefix, which SanitizeExtra sends
+// with it, are removed too.
+func (m *Message) Redact(keys ...string) *Message {
+	for _, k := range keys {
+		if !strings.HasPrefix(k, "_") {
+			k = "_" + k
+		}
+		delete(m.Extra, k)
+		delete(m.Extra, k[1:])
+	}
+	return m
+}
+
+// RedactAll removes all the additional fields from the message and returns it.
+func (m *Message) RedactAll() *Message {
+	for k := range m.Extra {
+		delete(m.Extra, k)
+	}
+	return m
+}
+
+// TemplateFuncs are the functions available to templates applied with
+// ApplyTemplate. They must be added before parsing the template:
+//
+//	tpl := template.Must(template.New("full").Funcs(graylog.TemplateFuncs).Parse(text))
+//
+// env returns the value of an environment variable, and now the current
+// time.
+var TemplateFuncs = template.FuncMap{
+	"env": os.Getenv,
+	"now": time.Now,
+}
+
+// ApplyTemplate executes tpl with the message as data, and sets the full
+// message to the result. The message is left untouched if the template
+// fails.
+func (m *Message) ApplyTemplate(tpl *template.Template) error {
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, m); err != nil {
+		return err
+	}
+	m.Full = buf.String()
+	return nil
+}
+
+// PrettyJSON returns the message as indented JSON, additional fields
+// included, for debugging purposes.
+func (m *Message) PrettyJSON() (string, error) {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// PrettyPrint writes the message to w as indented JSON, followed by a
+// newline.
+func PrettyPrint(w io.Writer, m *Message) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
+
+// FieldDiff describes a field whose value differs between two messages.
+// Field is the GELF name of the field, like "short_message" or "_user".
+type FieldDiff struct {
+	Field string
+	Got   interface{}
+	Want  interface{}
+}
+
+// Diff compares m with other and returns the fields that differ, GELF
+// fields first then additional fields sorted by name. A missing additional
+// field is reported with a nil value.
+func (m *Message) Diff(other *Message) []FieldDiff {
+	var diffs []FieldDiff
+	add := func(field string, got, want interface{}) {
+		if !reflect.DeepEqual(got, want) {
+			diffs = append(diffs, FieldDiff{Field: field, Got: got, Want: want})
+		}
+	}
+
+	add("version", m.Version, other.Version)
+	add("host", m.Host, other.Host)
+	add("short_message", m.Short, other.Short)
+	add("full_message", m.Full, other.Full)
+	add("timestamp", m.TimeUnix, other.TimeUnix)
+	add("level", m.Level, other.Level)
+	add("facility", m.Facility, other.Facility)
+	add("file", m.File, other.File)
+	add("line", m.Line, other.Line)
+
+	keys := make([]string, 0, len(m.Extra)+len(other.Extra))
+	for k := range m.Extra {
+		keys = append(keys, k)
+	}
+	for k := range other.Extra {
+		if _, ok := m.Extra[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		add(k, m.Extra[k], other.Extra[k])
+	}
+
+	return diffs
+}
+
+// ExtraKeys returns the names of the additional fields, sorted.
+func (m *Message) ExtraKeys() []string {
+	keys := make([]string, 0, len(m.Extra))
+	for k := range m.Extra {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ExtraValues returns the values of the additional fields, in the order of
+// ExtraKeys.
+func (m *Message) ExtraValues() []interface{} {
+	keys := m.ExtraKeys()
+	values := make([]interface{}, len(keys))
+	for i, k := range keys {
+		values[i] = m.Extra[k]
+	}
+	return values
+}
+
+// How the []byte values of additional fields are encoded when the message
+// is sent
+type ByteSliceEncoding int
+
+const (
+	ByteSliceBase64 ByteSliceEncoding = iota // base64 string, like encoding/json
+	ByteSliceHex                             // hexadecimal string
+	ByteSliceLength                          // number of bytes only
+)
+
+// encodeByteSlices returns m, or a copy of m in which the []byte additional
+// fields are replaced by their encoding. m itself is never modified.
+func encodeByteSlices(m *Message, enc ByteSliceEncoding) *Message {
+	var extra map[string]interface{}
+	for k, v := range m.Extra {
+		b, ok := v.([]byte)
+		if !ok {
+			continue
+		}
+		if extra == nil {
+			extra = make(map[string]interface{}, len(m.Extra))
+			for k, v := range m.Extra {
+				extra[k] = v
+			}
+		}
+		switch enc {
+		case ByteSliceHex:
+			extra[k] = hex.EncodeToString(b)
+		case ByteSliceLength:
+			extra[k] = len(b)
+		default:
+			extra[k] = base64.StdEncoding.EncodeToString(b)
+		}
+	}
+	if extra == nil {
+		return m
+	}
+
+	encoded := *m
+	encoded.Extra = extra
+	return &encoded
+}
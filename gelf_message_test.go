@@ -0,0 +1,487 @@
+package graylog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestLogf(t *testing.T) {
+	m := Logf(SyslogErrorLevel, "request %d failed", 42).
+		AppendExtra("user_id", 7).
+		AppendExtra("_path", "/login")
+
+	if m.Short != "request 42 failed" {
+		t.Errorf("Short: expected %q, got %q", "request 42 failed", m.Short)
+	}
+	if m.Version != "1.1" {
+		t.Errorf("Version: expected 1.1, got %s", m.Version)
+	}
+	if m.Level != SyslogErrorLevel {
+		t.Errorf("Level: expected %d, got %d", SyslogErrorLevel, m.Level)
+	}
+	if host, _ := os.Hostname(); m.Host != host {
+		t.Errorf("Host: expected %s, got %s", host, m.Host)
+	}
+	if m.TimeUnix == 0 {
+		t.Error("TimeUnix should be set")
+	}
+	if m.Extra["_user_id"] != 7 || m.Extra["_path"] != "/login" {
+		t.Errorf("unexpected extra fields: %v", m.Extra)
+	}
+}
+
+type codeError struct {
+	code int
+	err  error
+}
+
+func (e codeError) Error() string { return fmt.Sprintf("code %d: %s", e.code, e.err) }
+func (e codeError) Unwrap() error { return e.err }
+func (e codeError) Code() int     { return e.code }
+
+func TestWithErrorChain(t *testing.T) {
+	inner := errors.New("connection refused")
+	middle := fmt.Errorf("dial db: %w", inner)
+	outer := fmt.Errorf("load user: %w", middle)
+
+	m := Logf(SyslogErrorLevel, "failure").WithErrorChain(outer)
+
+	if m.Short != outer.Error() {
+		t.Errorf("Short: expected %q, got %q", outer.Error(), m.Short)
+	}
+	expectedFull := "load user: dial db: connection refused" +
+		"\n  caused by: dial db: connection refused" +
+		"\n  caused by: connection refused"
+	if m.Full != expectedFull {
+		t.Errorf("Full: expected %q, got %q", expectedFull, m.Full)
+	}
+	if m.Extra["_error_chain_depth"] != 3 {
+		t.Errorf("_error_chain_depth: expected 3, got %v", m.Extra["_error_chain_depth"])
+	}
+	if _, ok := m.Extra["_error_code"]; ok {
+		t.Error("_error_code should not be set")
+	}
+}
+
+func TestWithErrorChainCode(t *testing.T) {
+	err := codeError{code: 404, err: errors.New("no such user")}
+
+	m := Logf(SyslogErrorLevel, "failure").WithErrorChain(err)
+
+	if m.Extra["_error_code"] != 404 {
+		t.Errorf("_error_code: expected 404, got %v", m.Extra["_error_code"])
+	}
+	if m.Extra["_error_chain_depth"] != 2 {
+		t.Errorf("_error_chain_depth: expected 2, got %v", m.Extra["_error_chain_depth"])
+	}
+}
+
+func TestRedact(t *testing.T) {
+	m := Logf(SyslogInfoLevel, "login").
+		AppendExtra("user", "alice").
+		AppendExtra("password", "hunter2").
+		AppendExtra("token", "s3cr3t")
+
+	if m.Redact("_password", "_token", "_missing") != m {
+		t.Error("Redact should return the message")
+	}
+	if len(m.Extra) != 1 || m.Extra["_user"] != "alice" {
+		t.Errorf("unexpected extra fields after Redact: %v", m.Extra)
+	}
+
+	m.RedactAll()
+	if len(m.Extra) != 0 {
+		t.Errorf("unexpected extra fields after RedactAll: %v", m.Extra)
+	}
+
+	// nil Extra is a no-op
+	(&Message{}).Redact("_user").RedactAll()
+
+	// keys are prefixed like by AppendExtra
+	m = Logf(SyslogInfoLevel, "login").AppendExtra("password", "hunter2").AppendExtra("user", "alice")
+	m.Extra["token"] = "s3cr3t" // unprefixed, sent as _token
+	m.Redact("password", "_token")
+	if len(m.Extra) != 1 || m.Extra["_user"] != "alice" {
+		t.Errorf("unexpected extra fields after Redact of unprefixed keys: %v", m.Extra)
+	}
+}
+
+func TestApplyTemplate(t *testing.T) {
+	os.Setenv("GRAYLOG_TEST_CLUSTER", "eu-west")
+	defer os.Unsetenv("GRAYLOG_TEST_CLUSTER")
+
+	tpl := template.Must(template.New("full").Funcs(TemplateFuncs).Parse(
+		`{{.Short}} on {{env "GRAYLOG_TEST_CLUSTER"}} for {{index .Extra "_user"}} at {{now.Year}}`))
+
+	m := Logf(SyslogInfoLevel, "deployed").AppendExtra("user", "alice")
+	m.Full = "previous"
+	if err := m.ApplyTemplate(tpl); err != nil {
+		t.Fatalf("ApplyTemplate: %s", err)
+	}
+
+	expected := fmt.Sprintf("deployed on eu-west for alice at %d", time.Now().Year())
+	if m.Full != expected {
+		t.Errorf("Full: expected %q, got %q", expected, m.Full)
+	}
+}
+
+func TestApplyTemplateError(t *testing.T) {
+	tpl := template.Must(template.New("full").Parse(`{{.Short}} {{.Missing}}`))
+
+	m := Logf(SyslogInfoLevel, "deployed")
+	m.Full = "previous"
+	if err := m.ApplyTemplate(tpl); err == nil {
+		t.Error("ApplyTemplate should return template errors")
+	}
+	if m.Full != "previous" {
+		t.Errorf("Full should be left untouched on error, got %q", m.Full)
+	}
+}
+
+func TestPrettyJSON(t *testing.T) {
+	m := &Message{Version: "1.1", Host: "testing.local", Short: "pretty", Level: 6,
+		Extra: map[string]interface{}{"_user": "alice"}}
+
+	expected := `{
+  "version": "1.1",
+  "host": "testing.local",
+  "short_message": "pretty",
+  "full_message": "",
+  "timestamp": 0,
+  "level": 6,
+  "facility": "",
+  "file": "",
+  "line": 0,
+  "_user": "alice"
+}`
+	pretty, err := m.PrettyJSON()
+	if err != nil {
+		t.Fatalf("PrettyJSON: %s", err)
+	}
+	if pretty != expected {
+		t.Errorf("PrettyJSON: expected\n%s\ngot\n%s", expected, pretty)
+	}
+
+	var buf bytes.Buffer
+	if err := PrettyPrint(&buf, m); err != nil {
+		t.Fatalf("PrettyPrint: %s", err)
+	}
+	if buf.String() != expected+"\n" {
+		t.Errorf("PrettyPrint: expected\n%s\ngot\n%s", expected, buf.String())
+	}
+}
+
+func TestUnmarshalFromReader(t *testing.T) {
+	payload := `{"version":"1.1","host":"testing.local","short_message":"streamed",` +
+		`"timestamp":1577836800.5,"level":3,"line":12,"_user":"alice"}`
+
+	var m Message
+	if err := m.UnmarshalFromReader(strings.NewReader(payload)); err != nil {
+		t.Fatalf("UnmarshalFromReader: %s", err)
+	}
+	if m.Host != "testing.local" || m.Short != "streamed" || m.Level != 3 || m.Line != 12 || m.TimeUnix != 1577836800.5 {
+		t.Errorf("unexpected message: %+v", m)
+	}
+	if m.Extra["_user"] != "alice" {
+		t.Errorf("_user: expected alice, got %v", m.Extra["_user"])
+	}
+
+	if err := new(Message).UnmarshalFromReader(strings.NewReader(`{"version":`)); err == nil {
+		t.Error("UnmarshalFromReader should fail on truncated input")
+	}
+}
+
+func TestUnmarshalJSONTrailingData(t *testing.T) {
+	for _, payload := range []string{
+		`{"short_message":"a"} garbage`,
+		`{"short_message":"a"}{"short_message":"b"}`,
+		`{"short_message":"a"}}`,
+	} {
+		if err := new(Message).UnmarshalJSON([]byte(payload)); err == nil {
+			t.Errorf("UnmarshalJSON(%s) should fail on the trailing data", payload)
+		}
+	}
+
+	var m Message
+	if err := m.UnmarshalJSON([]byte(`{"short_message":"a"}` + "\n")); err != nil {
+		t.Errorf("UnmarshalJSON should accept trailing whitespace: %s", err)
+	}
+	if m.Short != "a" {
+		t.Errorf("short_message: expected a, got %q", m.Short)
+	}
+}
+
+func TestClone(t *testing.T) {
+	m := Logf(SyslogError, "original").AppendExtra("user", "alice")
+	c := m.Clone()
+	c.Short = "copy"
+	c.Extra["_user"] = "bob"
+	c.AppendExtra("added", true)
+
+	if m.Short != "original" || m.Extra["_user"] != "alice" || len(m.Extra) != 1 {
+		t.Errorf("Clone: the original was modified: %+v", m)
+	}
+	if c.Host != m.Host || c.Level != m.Level || c.TimeUnix != m.TimeUnix {
+		t.Errorf("Clone: expected the fields to be copied, got %+v", c)
+	}
+	if c := (&Message{Short: "no extra"}).Clone(); c.Extra != nil {
+		t.Errorf("Clone: expected a nil Extra to stay nil, got %v", c.Extra)
+	}
+}
+
+func TestUnmarshalJSONNumbers(t *testing.T) {
+	payload := `{"version":"1.1","host":"testing.local","short_message":"ids",` +
+		`"timestamp":1577836800.5,"level":3,"line":12,"_request_id":9007199254740993,"_ratio":0.25}`
+
+	var m Message
+	if err := json.Unmarshal([]byte(payload), &m); err != nil {
+		t.Fatalf("Unmarshal: %s", err)
+	}
+	if m.Level != 3 || m.Line != 12 || m.TimeUnix != 1577836800.5 {
+		t.Errorf("unexpected message: %+v", m)
+	}
+	id, ok := m.Extra["_request_id"].(json.Number)
+	if !ok {
+		t.Fatalf("_request_id: expected a json.Number, got %T", m.Extra["_request_id"])
+	}
+	if n, err := id.Int64(); err != nil || n != 9007199254740993 {
+		t.Errorf("_request_id: expected 9007199254740993, got %s (%v)", id, err)
+	}
+	if m.Extra["_ratio"] != json.Number("0.25") {
+		t.Errorf("_ratio: expected 0.25, got %#v", m.Extra["_ratio"])
+	}
+}
+
+func TestDiff(t *testing.T) {
+	got := &Message{Version: "1.1", Host: "a", Short: "same", Level: 3,
+		Extra: map[string]interface{}{"_user": "alice", "_extra": 1, "_same": true}}
+	want := &Message{Version: "1.1", Host: "b", Short: "same", Level: 4,
+		Extra: map[string]interface{}{"_user": "bob", "_missing": "x", "_same": true}}
+
+	expected := []FieldDiff{
+		{Field: "host", Got: "a", Want: "b"},
+		{Field: "level", Got: int32(3), Want: int32(4)},
+		{Field: "_extra", Got: 1, Want: nil},
+		{Field: "_missing", Got: nil, Want: "x"},
+		{Field: "_user", Got: "alice", Want: "bob"},
+	}
+	if diff := got.Diff(want); !reflect.DeepEqual(diff, expected) {
+		t.Errorf("Diff: expected %v, got %v", expected, diff)
+	}
+
+	if diff := got.Diff(got); len(diff) != 0 {
+		t.Errorf("Diff: expected no difference, got %v", diff)
+	}
+}
+
+func TestExtraKeys(t *testing.T) {
+	m := Logf(SyslogInfoLevel, "sorted").
+		AppendExtra("zone", "eu").
+		AppendExtra("app", "api").
+		AppendExtra("count", 3)
+
+	if keys := m.ExtraKeys(); !reflect.DeepEqual(keys, []string{"_app", "_count", "_zone"}) {
+		t.Errorf("ExtraKeys: got %v", keys)
+	}
+	if values := m.ExtraValues(); !reflect.DeepEqual(values, []interface{}{"api", 3, "eu"}) {
+		t.Errorf("ExtraValues: got %v", values)
+	}
+	if keys := new(Message).ExtraKeys(); len(keys) != 0 {
+		t.Errorf("ExtraKeys: expected no key, got %v", keys)
+	}
+}
+
+func TestSetLevelFromHTTPStatus(t *testing.T) {
+	for code, expected := range map[int]int32{
+		100: SyslogDebug,
+		199: SyslogDebug,
+		200: SyslogInformational,
+		299: SyslogInformational,
+		304: SyslogNotice,
+		404: SyslogWarning,
+		503: SyslogError,
+	} {
+		m := Logf(SyslogAlert, "GET /").SetLevelFromHTTPStatus(code)
+		if m.Level != expected {
+			t.Errorf("SetLevelFromHTTPStatus(%d): expected level %d, got %d", code, expected, m.Level)
+		}
+		if m.Extra["_http_status_code"] != code {
+			t.Errorf("SetLevelFromHTTPStatus(%d): expected _http_status_code %d, got %v", code, code, m.Extra["_http_status_code"])
+		}
+	}
+
+	// unknown classes don't change the level
+	if m := Logf(SyslogAlert, "GET /").SetLevelFromHTTPStatus(99); m.Level != SyslogAlert {
+		t.Errorf("SetLevelFromHTTPStatus(99): expected level %d, got %d", SyslogAlert, m.Level)
+	}
+}
+
+func TestWithKubernetesMetadataFromEnv(t *testing.T) {
+	os.Setenv("POD_NAME", "api-7d9f")
+	os.Setenv("POD_NAMESPACE", "billing")
+	os.Unsetenv("CONTAINER_NAME")
+	os.Unsetenv("NODE_NAME")
+	defer os.Unsetenv("POD_NAME")
+	defer os.Unsetenv("POD_NAMESPACE")
+
+	m := Logf(SyslogInformational, "started").WithKubernetesMetadataFromEnv()
+	expected := map[string]interface{}{
+		"_k8s_pod_name":       "api-7d9f",
+		"_k8s_namespace":      "billing",
+		"_k8s_container_name": "",
+		"_k8s_node_name":      "",
+	}
+	if !reflect.DeepEqual(m.Extra, expected) {
+		t.Errorf("Extra: expected %v, got %v", expected, m.Extra)
+	}
+}
+
+func TestWithGoroutineID(t *testing.T) {
+	ids := make(chan [2]int64)
+	for i := 0; i < 2; i++ {
+		go func() {
+			var id int64
+			fmt.Sscanf(string(debug.Stack()), "goroutine %d [", &id)
+			m := Logf(SyslogDebug, "tracing").WithGoroutineID()
+			ids <- [2]int64{id, m.Extra["_goroutine_id"].(int64)}
+		}()
+	}
+
+	first, second := <-ids, <-ids
+	for _, pair := range [][2]int64{first, second} {
+		if pair[0] == 0 || pair[0] != pair[1] {
+			t.Errorf("_goroutine_id: expected %d, got %d", pair[0], pair[1])
+		}
+	}
+	if first[0] == second[0] {
+		t.Errorf("_goroutine_id: expected different ids, got %d twice", first[0])
+	}
+}
+
+func TestSanitizeHost(t *testing.T) {
+	for host, expected := range map[string]string{
+		"web-01.example.com":     "web-01.example.com",
+		"Web_01.Example.COM":     "web-01.example.com",
+		"pod:api/7d9f":           "podapi7d9f",
+		strings.Repeat("a", 300): strings.Repeat("a", 255),
+		"cache.example.":         "cache.example",
+	} {
+		m := &Message{Host: host}
+		if got := m.SanitizeHost().Host; got != expected {
+			t.Errorf("SanitizeHost(%q): expected %q, got %q", host, expected, got)
+		}
+	}
+}
+
+func TestUnmarshalFromMap(t *testing.T) {
+	// as decoded by a YAML parser: integers aren't float64
+	raw := map[string]interface{}{
+		"version":       "1.1",
+		"host":          "testing.local",
+		"short_message": "from a map",
+		"timestamp":     1577836800,
+		"level":         int64(3),
+		"line":          12,
+		"_user":         "alice",
+		"unknown":       true,
+	}
+
+	var m Message
+	if err := m.UnmarshalFromMap(raw); err != nil {
+		t.Fatalf("UnmarshalFromMap: %s", err)
+	}
+	expected := Message{Version: "1.1", Host: "testing.local", Short: "from a map",
+		TimeUnix: 1577836800, Level: 3, Line: 12, Extra: map[string]interface{}{"_user": "alice"}}
+	if !reflect.DeepEqual(m, expected) {
+		t.Errorf("expected %+v, got %+v", expected, m)
+	}
+
+	for _, invalid := range []map[string]interface{}{{"host": 42}, {"level": "error"}} {
+		if err := new(Message).UnmarshalFromMap(invalid); err == nil {
+			t.Errorf("UnmarshalFromMap(%v) should fail", invalid)
+		}
+	}
+}
+
+func TestToLogrusFields(t *testing.T) {
+	m := &Message{Version: "1.1", Host: "testing.local", Short: "relayed", TimeUnix: 1577836800.5,
+		Level: SyslogWarning, Line: 12, Extra: map[string]interface{}{"_user_id": 7}}
+
+	expected := logrus.Fields{
+		"version":       "1.1",
+		"host":          "testing.local",
+		"short_message": "relayed",
+		"timestamp":     1577836800.5,
+		"level":         logrus.WarnLevel,
+		"line":          12,
+		"_user_id":      7,
+	}
+	if fields := m.ToLogrusFields(); !reflect.DeepEqual(fields, expected) {
+		t.Errorf("expected %v, got %v", expected, fields)
+	}
+
+	for level, expected := range map[int32]logrus.Level{
+		SyslogEmergency: logrus.PanicLevel, SyslogAlert: logrus.PanicLevel, SyslogCritical: logrus.FatalLevel,
+		SyslogError: logrus.ErrorLevel, SyslogNotice: logrus.InfoLevel, SyslogInformational: logrus.InfoLevel,
+		SyslogDebug: logrus.DebugLevel,
+	} {
+		if got := (&Message{Level: level}).ToLogrusFields()["level"]; got != expected {
+			t.Errorf("level %d: expected %s, got %v", level, expected, got)
+		}
+	}
+}
+
+func TestWithResponseTime(t *testing.T) {
+	m := (&Message{}).WithResponseTime(1500 * time.Microsecond).WithResponseTimeNs(1500 * time.Microsecond)
+	if v, ok := m.Extra["_response_time_ms"].(int64); !ok || v != 1 {
+		t.Errorf("_response_time_ms: expected int64 1, got %#v", m.Extra["_response_time_ms"])
+	}
+	if v, ok := m.Extra["_response_time_ns"].(int64); !ok || v != 1500000 {
+		t.Errorf("_response_time_ns: expected int64 1500000, got %#v", m.Extra["_response_time_ns"])
+	}
+
+	m = (&Message{}).WithResponseTime(0)
+	if v, ok := m.Extra["_response_time_ms"]; !ok || v != int64(0) {
+		t.Errorf("_response_time_ms: expected int64 0, got %#v", v)
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	m := &Message{Version: "1.1", Host: "testing.local", Short: "flat", TimeUnix: 1577836800.5,
+		Level: SyslogNotice, Extra: map[string]interface{}{"_user": "alice"}}
+
+	flat := m.Flatten()
+	expected := map[string]interface{}{
+		"version": "1.1", "host": "testing.local", "short_message": "flat", "full_message": "",
+		"timestamp": 1577836800.5, "level": SyslogNotice, "facility": "", "file": "", "line": 0,
+		"_user": "alice",
+	}
+	if !reflect.DeepEqual(flat, expected) {
+		t.Errorf("expected %v, got %v", expected, flat)
+	}
+
+	flat["_user"] = "bob"
+	if m.Extra["_user"] != "alice" {
+		t.Error("Flatten should return a new map")
+	}
+
+	var buf bytes.Buffer
+	tpl := template.Must(template.New("").Parse(`{{.host}} {{._user}}: {{.short_message}}`))
+	if err := tpl.Execute(&buf, m.Flatten()); err != nil {
+		t.Fatalf("Execute: %s", err)
+	}
+	if buf.String() != "testing.local alice: flat" {
+		t.Errorf("template: got %q", buf.String())
+	}
+}
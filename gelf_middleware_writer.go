@@ -0,0 +1,57 @@
+package graylog
+
+import (
+	"os"
+	"runtime"
+)
+
+// WriterMiddleware transforms a message before it is written. Returning a
+// nil message and no error drops the message silently.
+type WriterMiddleware func(m *Message) (*Message, error)
+
+// MiddlewareWriter implements the GELFWriter interface, running each message
+// through a chain of middlewares before writing it to another GELFWriter.
+type MiddlewareWriter struct {
+	writer GELFWriter
+	chain  []WriterMiddleware
+}
+
+// NewMiddlewareWriter wraps w, running the middlewares in order on every
+// message.
+func NewMiddlewareWriter(w GELFWriter, middlewares ...WriterMiddleware) *MiddlewareWriter {
+	return &MiddlewareWriter{writer: w, chain: middlewares}
+}
+
+// Use appends mw to the chain and returns w, to compose the chain step by
+// step. It must not be called concurrently with WriteMessage.
+func (w *MiddlewareWriter) Use(mw WriterMiddleware) *MiddlewareWriter {
+	w.chain = append(w.chain, mw)
+	return w
+}
+
+// WriteMessage runs the middlewares on m, and writes the result unless a
+// middleware fails or drops it.
+func (w *MiddlewareWriter) WriteMessage(m *Message) (err error) {
+	for _, mw := range w.chain {
+		if m, err = mw(m); err != nil || m == nil {
+			return
+		}
+	}
+	return w.writer.WriteMessage(m)
+}
+
+// ProcessMetadataMiddleware adds the process id, name and Go version to
+// each message, as the _pid, _process and _go_version fields. They are
+// looked up once, when the middleware is created. The fields are added to a
+// clone of the message, so the caller's Extra map is left untouched.
+func ProcessMetadataMiddleware() WriterMiddleware {
+	pid := os.Getpid()
+	process := os.Args[0]
+	goVersion := runtime.Version()
+
+	return func(m *Message) (*Message, error) {
+		return m.Clone().AppendExtra("_pid", pid).
+			AppendExtra("_process", process).
+			AppendExtra("_go_version", goVersion), nil
+	}
+}
@@ -0,0 +1,89 @@
+package graylog
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestMiddlewareWriter(t *testing.T) {
+	dest := &flakyWriter{}
+	dropDebug := func(m *Message) (*Message, error) {
+		if m.Level == SyslogDebug {
+			return nil, nil
+		}
+		return m, nil
+	}
+	w := NewMiddlewareWriter(dest, dropDebug)
+
+	w.WriteMessage(Logf(SyslogDebug, "dropped"))
+	w.WriteMessage(Logf(SyslogInformational, "kept"))
+	if got := dest.messages(); !reflect.DeepEqual(got, []string{"kept"}) {
+		t.Errorf("messages: expected [kept], got %v", got)
+	}
+
+	failing := NewMiddlewareWriter(dest, func(m *Message) (*Message, error) {
+		return nil, errors.New("rejected")
+	})
+	if err := failing.WriteMessage(Logf(SyslogInformational, "rejected")); err == nil {
+		t.Error("WriteMessage should return the middleware error")
+	}
+}
+
+func TestMiddlewareWriterUse(t *testing.T) {
+	dest := &flakyWriter{}
+	var order []string
+	step := func(name string) WriterMiddleware {
+		return func(m *Message) (*Message, error) {
+			order = append(order, name)
+			m.Short += " " + name
+			return m, nil
+		}
+	}
+	w := NewMiddlewareWriter(dest, step("first"))
+	if w.Use(step("second")).Use(step("third")) != w {
+		t.Error("Use should return the writer")
+	}
+
+	w.WriteMessage(Logf(SyslogInformational, "ran"))
+	if !reflect.DeepEqual(order, []string{"first", "second", "third"}) {
+		t.Errorf("expected the middlewares to run in order, got %v", order)
+	}
+	if got := dest.messages(); !reflect.DeepEqual(got, []string{"ran first second third"}) {
+		t.Errorf("messages: unexpected %v", got)
+	}
+}
+
+func TestProcessMetadataMiddleware(t *testing.T) {
+	m, err := ProcessMetadataMiddleware()(Logf(SyslogInformational, "started"))
+	if err != nil {
+		t.Fatalf("middleware: %s", err)
+	}
+	expected := map[string]interface{}{
+		"_pid":        os.Getpid(),
+		"_process":    os.Args[0],
+		"_go_version": runtime.Version(),
+	}
+	if !reflect.DeepEqual(m.Extra, expected) {
+		t.Errorf("Extra: expected %v, got %v", expected, m.Extra)
+	}
+}
+
+func TestProcessMetadataMiddlewareCopiesExtra(t *testing.T) {
+	original := Logf(SyslogInformational, "started").AppendExtra("_user", "alice")
+	m, err := ProcessMetadataMiddleware()(original)
+	if err != nil {
+		t.Fatalf("middleware: %s", err)
+	}
+	if m == original {
+		t.Error("the middleware should return a copy of the message")
+	}
+	if expected := map[string]interface{}{"_user": "alice"}; !reflect.DeepEqual(original.Extra, expected) {
+		t.Errorf("original Extra: expected %v, got %v", expected, original.Extra)
+	}
+	if len(m.Extra) != 4 || m.Extra["_user"] != "alice" {
+		t.Errorf("Extra: expected _user and the process fields, got %v", m.Extra)
+	}
+}
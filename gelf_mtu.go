@@ -0,0 +1,62 @@
+package graylog
+
+import (
+	"fmt"
+	"net"
+)
+
+// ChunkSizeForMTU returns the largest chunk size avoiding IP fragmentation
+// on the named network interface: its MTU, minus the IPv6 and UDP headers,
+// up to 65507 bytes. As the address family isn't known, the largest, IPv6,
+// header is subtracted; use ChunkSizeForConn to subtract the IPv4 one for
+// IPv4 connections. Like for WithChunkSize, the chunk size is the size of
+// the UDP datagrams, GELF chunk header included.
+func ChunkSizeForMTU(interfaceName string) (int, error) {
+	iface, err := net.InterfaceByName(interfaceName)
+	if err != nil {
+		return 0, err
+	}
+	return chunkSizeFor(iface, true)
+}
+
+// ChunkSizeForConn is ChunkSizeForMTU for the interface conn is bound to,
+// found from its local address, subtracting the header of its IP version.
+func ChunkSizeForConn(conn net.Conn) (int, error) {
+	var ip net.IP
+	switch addr := conn.LocalAddr().(type) {
+	case *net.UDPAddr:
+		ip = addr.IP
+	case *net.TCPAddr:
+		ip = addr.IP
+	default:
+		return 0, fmt.Errorf("unsupported local address %s", conn.LocalAddr())
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return 0, err
+	}
+	for i := range ifaces {
+		addrs, err := ifaces[i].Addrs()
+		if err != nil {
+			return 0, err
+		}
+		for _, a := range addrs {
+			if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				return chunkSizeFor(&ifaces[i], ip.To4() == nil)
+			}
+		}
+	}
+	return 0, fmt.Errorf("no interface found for local address %s", ip)
+}
+
+func chunkSizeFor(iface *net.Interface, ipv6 bool) (int, error) {
+	size := iface.MTU - datagramOverhead(ipv6)
+	if size < minChunkSize {
+		return 0, fmt.Errorf("interface %s MTU is too small: %d", iface.Name, iface.MTU)
+	}
+	if size > maxChunkSize {
+		size = maxChunkSize
+	}
+	return size, nil
+}
@@ -0,0 +1,73 @@
+package graylog
+
+import (
+	"net"
+	"runtime"
+	"testing"
+)
+
+func TestChunkSizeForMTU(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the loopback interface is named lo on Linux only")
+	}
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skipf("no loopback interface: %s", err)
+	}
+	expected := func(overhead int) int {
+		if size := lo.MTU - overhead; size < maxChunkSize {
+			return size
+		}
+		return maxChunkSize
+	}
+
+	size, err := ChunkSizeForMTU("lo")
+	if err != nil {
+		t.Fatalf("ChunkSizeForMTU: %s", err)
+	}
+	if size != expected(40+8) {
+		t.Errorf("ChunkSizeForMTU: expected %d, got %d", expected(40+8), size)
+	}
+
+	conn, err := net.Dial("udp", "127.0.0.1:12201")
+	if err != nil {
+		t.Fatalf("Dial: %s", err)
+	}
+	defer conn.Close()
+	if size, err = ChunkSizeForConn(conn); err != nil || size != expected(20+8) {
+		t.Errorf("ChunkSizeForConn: expected %d, got %d (%v)", expected(20+8), size, err)
+	}
+
+	w, err := NewUDPWriter(conn.RemoteAddr().String(), WithChunkSize(size))
+	if err != nil {
+		t.Fatalf("NewUDPWriter: %s", err)
+	}
+	if got := w.(*UDPWriter).chunkSize; got != size {
+		t.Errorf("WithChunkSize: expected %d, got %d", size, got)
+	}
+
+	if conn6, err := net.Dial("udp", "[::1]:12201"); err != nil {
+		t.Logf("no IPv6 support: %s", err)
+	} else {
+		defer conn6.Close()
+		if size, err := ChunkSizeForConn(conn6); err != nil || size != expected(40+8) {
+			t.Errorf("ChunkSizeForConn over IPv6: expected %d, got %d (%v)", expected(40+8), size, err)
+		}
+	}
+
+	if _, err := ChunkSizeForMTU("doesnotexist0"); err == nil {
+		t.Error("ChunkSizeForMTU should fail on unknown interfaces")
+	}
+}
+
+func TestChunkSizeForInterface(t *testing.T) {
+	ethernet := &net.Interface{Name: "eth0", MTU: 1500}
+	for ipv6, expected := range map[bool]int{false: 1472, true: 1452} {
+		if size, err := chunkSizeFor(ethernet, ipv6); err != nil || size != expected {
+			t.Errorf("IPv6 %t: expected %d, got %d (%v)", ipv6, expected, size, err)
+		}
+	}
+	if _, err := chunkSizeFor(&net.Interface{Name: "tiny0", MTU: 140}, true); err == nil {
+		t.Error("chunkSizeFor should fail when the chunks would be too small")
+	}
+}
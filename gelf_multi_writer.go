@@ -0,0 +1,90 @@
+package graylog
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// MultiWriter implements the GELFWriter interface, sending each message to
+// all its Writers, for instance to Graylog and to an OpenTelemetry
+// collector from a single hook. A failing writer doesn't prevent the
+// others from receiving the message.
+type MultiWriter struct {
+	Writers []GELFWriter
+	// Parallel makes WriteMessage call the writers concurrently, instead
+	// of one after the other.
+	Parallel bool
+}
+
+// MultiError holds the errors returned by the writers of a MultiWriter, in
+// the order of the writers.
+type MultiError []error
+
+func (e MultiError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d writers failed: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// NewMultiWriter returns a MultiWriter sending the messages to writers.
+// This is the way to send the messages of a hook to several servers:
+//
+//	hook := graylog.NewGraylogHook("graylog:12201", nil)
+//	hook.SetWriter(graylog.NewMultiWriter(hook.Writer(), collectorWriter))
+func NewMultiWriter(writers ...GELFWriter) GELFWriter {
+	return &MultiWriter{Writers: writers}
+}
+
+// WriteMessage sends m to all the writers. The message is shared, so the
+// writers must not modify it. If some writers fail, a MultiError is
+// returned.
+func (w *MultiWriter) WriteMessage(m *Message) error {
+	errs := make([]error, len(w.Writers))
+	if w.Parallel {
+		var wg sync.WaitGroup
+		for i, writer := range w.Writers {
+			wg.Add(1)
+			go func(i int, writer GELFWriter) {
+				defer wg.Done()
+				errs[i] = writer.WriteMessage(m)
+			}(i, writer)
+		}
+		wg.Wait()
+	} else {
+		for i, writer := range w.Writers {
+			errs[i] = writer.WriteMessage(m)
+		}
+	}
+
+	var failed MultiError
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) > 0 {
+		return failed
+	}
+	return nil
+}
+
+// Close closes the writers implementing io.Closer, and returns a
+// MultiError if some fail.
+func (w *MultiWriter) Close() error {
+	var failed MultiError
+	for _, writer := range w.Writers {
+		if c, ok := writer.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				failed = append(failed, err)
+			}
+		}
+	}
+	if len(failed) > 0 {
+		return failed
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package graylog
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMultiWriter(t *testing.T) {
+	for _, parallel := range []bool{false, true} {
+		first, failing, last := &flakyWriter{}, &flakyWriter{failures: 2}, &flakyWriter{}
+		w := NewMultiWriter(first, failing, last)
+		w.(*MultiWriter).Parallel = parallel
+
+		err := w.WriteMessage(Logf(SyslogInformational, "one"))
+		errs, ok := err.(MultiError)
+		if !ok || len(errs) != 1 {
+			t.Fatalf("parallel %t: expected a MultiError with 1 error, got %#v", parallel, err)
+		}
+		if !strings.Contains(err.Error(), "1 writers failed: network is unreachable") {
+			t.Errorf("parallel %t: unexpected error %q", parallel, err)
+		}
+
+		// the failing writer doesn't stop the others
+		w.WriteMessage(Logf(SyslogInformational, "two"))
+		if err := w.WriteMessage(Logf(SyslogInformational, "three")); err != nil {
+			t.Errorf("parallel %t: WriteMessage: %s", parallel, err)
+		}
+		for _, dest := range []*flakyWriter{first, last} {
+			if got := dest.messages(); !reflect.DeepEqual(got, []string{"one", "two", "three"}) {
+				t.Errorf("parallel %t: expected all the messages, got %v", parallel, got)
+			}
+		}
+		if got := failing.messages(); !reflect.DeepEqual(got, []string{"three"}) {
+			t.Errorf("parallel %t: expected the last message, got %v", parallel, got)
+		}
+	}
+}
+
+func TestSetWriterMultiWriter(t *testing.T) {
+	dest := &flakyWriter{}
+	hook := NewGraylogHook("127.0.0.1:0", nil)
+	if err := hook.SetWriter(NewMultiWriter(hook.Writer(), dest)); err != nil {
+		t.Fatalf("SetWriter: %s", err)
+	}
+	if _, ok := hook.Writer().(*MultiWriter); !ok {
+		t.Errorf("expected the hook writer to be a MultiWriter, got %T", hook.Writer())
+	}
+	if err := hook.SetWriter((*UDPWriter)(nil)); err == nil {
+		t.Error("Setting a nil writer should raise an error")
+	}
+}
+
+func TestMultiWriterParallelUDP(t *testing.T) {
+	var writers []GELFWriter
+	var readers []*Reader
+	for i := 0; i < 2; i++ {
+		r, err := NewReader("127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("NewReader: %s", err)
+		}
+		defer r.conn.Close()
+		w, err := NewWriter(r.Addr())
+		if err != nil {
+			t.Fatalf("NewWriter: %s", err)
+		}
+		readers = append(readers, r)
+		writers = append(writers, w)
+	}
+	w := &MultiWriter{Writers: writers, Parallel: true}
+
+	// both writers marshal and validate the same message concurrently
+	m := Logf(SyslogInformational, "shared").AppendExtra("user", "alice")
+	if err := w.WriteMessage(m); err != nil {
+		t.Fatalf("WriteMessage: %s", err)
+	}
+	for _, r := range readers {
+		got, err := r.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage: %s", err)
+		}
+		if got.Short != "shared" || got.Extra["_user"] != "alice" {
+			t.Errorf("unexpected message: %+v", got)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package graylog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// NegotiateVersion sends a version probe to the server and sets GELFVersion
+// from its answer, or to "1.1" if ctx is done before an answer is received.
+// The probe format is documented in the package documentation.
+func (w *UDPWriter) NegotiateVersion(ctx context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.conn == nil {
+		return ErrWriterClosed
+	}
+
+	probe := Message{
+		Version: GELFVersion,
+		Host:    w.hostname,
+		Short:   "GELF version probe",
+		Extra:   map[string]interface{}{"_probe": true},
+	}
+	mBytes, err := json.Marshal(&probe)
+	if err != nil {
+		return err
+	}
+	if _, err = w.conn.Write(mBytes); err != nil {
+		return fmt.Errorf("version probe: %s", err)
+	}
+
+	// Unblock the read below as soon as the context is done.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			w.conn.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	defer w.conn.SetReadDeadline(time.Time{})
+
+	w.GELFVersion = GELFVersion
+	buf := make([]byte, ChunkSize)
+	for ctx.Err() == nil {
+		n, err := w.conn.Read(buf)
+		if err != nil {
+			// No echo service answered: a refused port or a
+			// timeout both mean the default version is used.
+			return nil
+		}
+
+		var answer struct {
+			Version string `json:"version"`
+		}
+		if json.Unmarshal(buf[:n], &answer) == nil && answer.Version != "" {
+			w.GELFVersion = answer.Version
+			return nil
+		}
+	}
+	return nil
+}
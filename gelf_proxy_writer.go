@@ -0,0 +1,65 @@
+package graylog
+
+import (
+	"errors"
+	"sync"
+)
+
+// Set graylog.ProxyBufferSize = <value> _before_ calling NewWriterProxy
+// Once the buffer is full, messages are dropped until a writer is set.
+var ProxyBufferSize = 1024
+
+// WriterProxy implements the GELFWriter interface, and can be used before
+// the actual writer is known (e.g. before service discovery completes).
+// Messages are buffered until SetWriter is called.
+type WriterProxy struct {
+	mu     sync.Mutex
+	writer GELFWriter
+	buffer []*Message
+	size   int
+}
+
+// NewWriterProxy returns a WriterProxy with no writer set
+func NewWriterProxy() *WriterProxy {
+	return &WriterProxy{size: ProxyBufferSize}
+}
+
+// WriteMessage sends the message to the writer, or buffers it if no writer
+// was set yet. The message must not be modified afterwards, as it may be
+// sent later.
+func (p *WriterProxy) WriteMessage(m *Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.writer != nil {
+		return p.writer.WriteMessage(m)
+	}
+	if len(p.buffer) >= p.size {
+		return errors.New("proxy buffer is full, message dropped")
+	}
+	p.buffer = append(p.buffer, m)
+	return nil
+}
+
+// SetWriter sets the writer messages are sent to, after flushing the
+// buffered messages to it. The first error returned while flushing, if any,
+// is returned; the remaining messages are still sent.
+func (p *WriterProxy) SetWriter(w GELFWriter) error {
+	if w == nil {
+		return errors.New("writer can't be nil")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var err error
+	for _, m := range p.buffer {
+		if werr := w.WriteMessage(m); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	p.buffer = nil
+	p.writer = w
+
+	return err
+}
@@ -0,0 +1,42 @@
+package graylog
+
+import "testing"
+
+func TestWriterProxy(t *testing.T) {
+	defer func(size int) { ProxyBufferSize = size }(ProxyBufferSize)
+	ProxyBufferSize = 2
+
+	p := NewWriterProxy()
+	for _, short := range []string{"one", "two"} {
+		if err := p.WriteMessage(&Message{Short: short}); err != nil {
+			t.Errorf("WriteMessage: %s", err)
+		}
+	}
+	if err := p.WriteMessage(&Message{Short: "three"}); err == nil {
+		t.Error("WriteMessage should fail once the buffer is full")
+	}
+
+	w := &flakyWriter{}
+	if err := p.SetWriter(w); err != nil {
+		t.Fatalf("SetWriter: %s", err)
+	}
+	if err := p.WriteMessage(&Message{Short: "four"}); err != nil {
+		t.Errorf("WriteMessage: %s", err)
+	}
+
+	expected := []string{"one", "two", "four"}
+	got := w.messages()
+	if len(got) != len(expected) {
+		t.Fatalf("expected messages %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("expected messages %v, got %v", expected, got)
+			break
+		}
+	}
+
+	if p.SetWriter(nil) == nil {
+		t.Error("Setting a nil writer should raise an error")
+	}
+}
@@ -10,6 +10,9 @@ import (
 	"net"
 	"strings"
 	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
 )
 
 type Reader struct {
@@ -58,15 +61,13 @@ func (r *Reader) Read(p []byte) (int, error) {
 }
 
 func (r *Reader) ReadMessage() (*Message, error) {
-	cBuf := make([]byte, ChunkSize)
+	cBuf := make([]byte, maxChunkSize)
 	var (
 		err        error
 		n, length  int
-		buf        bytes.Buffer
 		cid, ocid  []byte
 		seq, total uint8
 		cHead      []byte
-		cReader    io.Reader
 		chunks     [][]byte
 	)
 
@@ -107,16 +108,41 @@ func (r *Reader) ReadMessage() (*Message, error) {
 			//fmt.Printf("appending %d %v\n", i, chunks[i])
 			cBuf = append(cBuf, chunks[i]...)
 		}
-		cHead = cBuf[:2]
 	}
 
-	// the data we get from the wire is compressed
-	if bytes.Equal(cHead, magicGzip) {
-		cReader, err = gzip.NewReader(bytes.NewReader(cBuf))
+	return decodeMessage(cBuf)
+}
+
+// decodeMessage decompresses and unmarshals the payload of a message,
+// once its chunks are reassembled.
+func decodeMessage(p []byte) (*Message, error) {
+	var (
+		err     error
+		buf     bytes.Buffer
+		cReader io.Reader
+	)
+	if len(p) < 2 {
+		return nil, fmt.Errorf("short message: %d bytes", len(p))
+	}
+	cHead := p[:2]
+
+	if p[0] == '{' {
+		// uncompressed JSON
+		cReader = bytes.NewReader(p)
+	} else if bytes.Equal(cHead, magicGzip) {
+		cReader, err = gzip.NewReader(bytes.NewReader(p))
+	} else if bytes.HasPrefix(p, magicSnappy) {
+		cReader = snappy.NewReader(bytes.NewReader(p))
+	} else if bytes.HasPrefix(p, magicZstd) {
+		var zr *zstd.Decoder
+		if zr, err = zstd.NewReader(bytes.NewReader(p)); err == nil {
+			defer zr.Close()
+			cReader = zr
+		}
 	} else if cHead[0] == magicZlib[0] &&
 		(int(cHead[0])*256+int(cHead[1]))%31 == 0 {
 		// zlib is slightly more complicated, but correct
-		cReader, err = zlib.NewReader(bytes.NewReader(cBuf))
+		cReader, err = zlib.NewReader(bytes.NewReader(p))
 	} else {
 		return nil, fmt.Errorf("unknown magic: %x %v", cHead, cHead)
 	}
@@ -0,0 +1,93 @@
+package graylog
+
+import (
+	"sync"
+	"time"
+)
+
+// BufferedRetryWriter implements the GELFWriter interface, wrapping another
+// GELFWriter to ride out transient network failures. When a write fails,
+// the message is kept in memory and delivery is retried every
+// RetryInterval. Until the buffer is flushed, new messages are buffered
+// too, so that they are delivered in order.
+type BufferedRetryWriter struct {
+	RetryInterval time.Duration
+	MaxBuffered   int // oldest messages are dropped once the buffer is full
+
+	mu       sync.Mutex
+	writer   GELFWriter
+	buffer   []*Message
+	retrying bool
+}
+
+// NewBufferedRetryWriter wraps w, buffering up to maxBuffered messages
+// while w is failing.
+func NewBufferedRetryWriter(w GELFWriter, maxBuffered int, retryInterval time.Duration) *BufferedRetryWriter {
+	return &BufferedRetryWriter{
+		RetryInterval: retryInterval,
+		MaxBuffered:   maxBuffered,
+		writer:        w,
+	}
+}
+
+// WriteMessage sends the message, or buffers it if the underlying writer
+// fails or has buffered messages pending. The message must not be modified
+// afterwards, as it may be sent later.
+func (w *BufferedRetryWriter) WriteMessage(m *Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buffer) == 0 {
+		err := w.writer.WriteMessage(m)
+		if err == nil || w.MaxBuffered <= 0 {
+			return err
+		}
+	}
+
+	w.push(m)
+	if !w.retrying {
+		w.retrying = true
+		go w.retry()
+	}
+	return nil
+}
+
+// BufferedCount returns the number of messages waiting to be delivered
+func (w *BufferedRetryWriter) BufferedCount() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return len(w.buffer)
+}
+
+// push appends m to the buffer, dropping the oldest message when full.
+// w.mu must be held.
+func (w *BufferedRetryWriter) push(m *Message) {
+	if len(w.buffer) >= w.MaxBuffered {
+		w.buffer[0] = nil
+		w.buffer = w.buffer[1:]
+	}
+	w.buffer = append(w.buffer, m)
+}
+
+// retry delivers buffered messages until the buffer is empty
+func (w *BufferedRetryWriter) retry() {
+	for {
+		time.Sleep(w.RetryInterval)
+
+		w.mu.Lock()
+		for len(w.buffer) > 0 {
+			if err := w.writer.WriteMessage(w.buffer[0]); err != nil {
+				break
+			}
+			w.buffer[0] = nil
+			w.buffer = w.buffer[1:]
+		}
+		if len(w.buffer) == 0 {
+			w.retrying = false
+			w.mu.Unlock()
+			return
+		}
+		w.mu.Unlock()
+	}
+}
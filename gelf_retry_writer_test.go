@@ -0,0 +1,87 @@
+package graylog
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyWriter fails the first `failures` writes and records the others
+type flakyWriter struct {
+	mu       sync.Mutex
+	failures int
+	written  []string
+}
+
+func (w *flakyWriter) WriteMessage(m *Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.failures > 0 {
+		w.failures--
+		return errors.New("network is unreachable")
+	}
+	w.written = append(w.written, m.Short)
+	return nil
+}
+
+func (w *flakyWriter) messages() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return append([]string(nil), w.written...)
+}
+
+func TestBufferedRetryWriter(t *testing.T) {
+	flaky := &flakyWriter{failures: 3}
+	w := NewBufferedRetryWriter(flaky, 10, 5*time.Millisecond)
+
+	for _, short := range []string{"one", "two", "three"} {
+		if err := w.WriteMessage(&Message{Short: short}); err != nil {
+			t.Errorf("WriteMessage: %s", err)
+		}
+	}
+	if n := w.BufferedCount(); n != 3 {
+		t.Errorf("BufferedCount: expected 3, got %d", n)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for w.BufferedCount() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("buffer not flushed, %d messages left", w.BufferedCount())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := w.WriteMessage(&Message{Short: "four"}); err != nil {
+		t.Errorf("WriteMessage: %s", err)
+	}
+
+	expected := []string{"one", "two", "three", "four"}
+	got := flaky.messages()
+	if len(got) != len(expected) {
+		t.Fatalf("expected messages %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("expected messages %v, got %v", expected, got)
+			break
+		}
+	}
+}
+
+func TestBufferedRetryWriterDropsOldest(t *testing.T) {
+	flaky := &flakyWriter{failures: 100}
+	w := NewBufferedRetryWriter(flaky, 2, time.Hour)
+
+	for _, short := range []string{"one", "two", "three"} {
+		w.WriteMessage(&Message{Short: short})
+	}
+	if n := w.BufferedCount(); n != 2 {
+		t.Errorf("BufferedCount: expected 2, got %d", n)
+	}
+	if w.buffer[0].Short != "two" {
+		t.Errorf("oldest message should have been dropped, got %s", w.buffer[0].Short)
+	}
+}
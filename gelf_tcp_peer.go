@@ -0,0 +1,33 @@
+//go:build !windows && !plan9 && !js && !wasip1
+// +build !windows,!plan9,!js,!wasip1
+
+package graylog
+
+import (
+	"net"
+	"syscall"
+)
+
+// peerClosed reports whether the peer closed conn, peeking at the socket
+// without blocking nor consuming any data. Connections that don't expose
+// their socket, like TLS ones, are reported open.
+func peerClosed(conn net.Conn) bool {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return false
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return false
+	}
+
+	closed := false
+	rc.Read(func(fd uintptr) bool {
+		var b [1]byte
+		// the socket is non-blocking, EAGAIN means it is still open
+		n, _, err := syscall.Recvfrom(int(fd), b[:], syscall.MSG_PEEK)
+		closed = (n == 0 && err == nil) || err == syscall.ECONNRESET
+		return true
+	})
+	return closed
+}
@@ -0,0 +1,12 @@
+//go:build windows || plan9 || js || wasip1
+// +build windows plan9 js wasip1
+
+package graylog
+
+import "net"
+
+// peerClosed always reports conn open: the peer closing it is only noticed
+// when a write fails.
+func peerClosed(conn net.Conn) bool {
+	return false
+}
@@ -0,0 +1,130 @@
+package graylog
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// ErrReaderClosed is returned by TCPReader.ReadMessage once the reader is
+// closed.
+var ErrReaderClosed = errors.New("reader is closed")
+
+// TCPReader receives null byte delimited GELF messages on a TCP port, from
+// any number of clients, for integration tests or relays.
+type TCPReader struct {
+	listener  net.Listener
+	messages  chan tcpReadResult
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu    sync.Mutex
+	conns map[net.Conn]bool
+}
+
+type tcpReadResult struct {
+	msg *Message
+	err error
+}
+
+// NewTCPReader listens for GELF messages on the TCP address addr.
+func NewTCPReader(addr string) (*TCPReader, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("Listen: %s", err)
+	}
+
+	r := &TCPReader{
+		listener: l,
+		messages: make(chan tcpReadResult),
+		done:     make(chan struct{}),
+		conns:    make(map[net.Conn]bool),
+	}
+	go r.accept()
+	return r, nil
+}
+
+// Addr returns the address the reader listens on
+func (r *TCPReader) Addr() string {
+	return r.listener.Addr().String()
+}
+
+// ReadMessage blocks until a message is received from any client. Frames
+// which can't be decoded are returned as errors.
+func (r *TCPReader) ReadMessage() (*Message, error) {
+	select {
+	case res := <-r.messages:
+		return res.msg, res.err
+	case <-r.done:
+		return nil, ErrReaderClosed
+	}
+}
+
+// Close stops listening and closes the client connections.
+func (r *TCPReader) Close() error {
+	var err error
+	r.closeOnce.Do(func() {
+		close(r.done)
+		err = r.listener.Close()
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		for conn := range r.conns {
+			conn.Close()
+		}
+	})
+	return err
+}
+
+// accept serves each client connection in its own goroutine, until the
+// listener is closed.
+func (r *TCPReader) accept() {
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		r.mu.Lock()
+		select {
+		case <-r.done:
+			r.mu.Unlock()
+			conn.Close()
+			return
+		default:
+		}
+		r.conns[conn] = true
+		r.mu.Unlock()
+
+		go r.serve(conn)
+	}
+}
+
+// serve decodes the frames sent on conn, until it is closed. An incomplete
+// last frame is dropped.
+func (r *TCPReader) serve(conn net.Conn) {
+	defer func() {
+		r.mu.Lock()
+		delete(r.conns, conn)
+		r.mu.Unlock()
+		conn.Close()
+	}()
+
+	br := bufio.NewReader(conn)
+	for {
+		frame, err := br.ReadBytes(0)
+		if err != nil {
+			return
+		}
+
+		var res tcpReadResult
+		res.msg, res.err = decodeMessage(frame[:len(frame)-1])
+		select {
+		case r.messages <- res:
+		case <-r.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package graylog
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestTCPReader(t *testing.T) {
+	r, err := NewTCPReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewTCPReader: %s", err)
+	}
+	defer r.Close()
+
+	var wg sync.WaitGroup
+	for _, short := range []string{"first", "second", "third"} {
+		w, err := NewWriter("tcp://" + r.Addr())
+		if err != nil {
+			t.Fatalf("NewWriter: %s", err)
+		}
+		defer w.(*TCPWriter).Close()
+
+		wg.Add(1)
+		go func(short string) {
+			defer wg.Done()
+			if err := w.WriteMessage(Logf(6, short)); err != nil {
+				t.Errorf("WriteMessage: %s", err)
+			}
+		}(short)
+	}
+	wg.Wait()
+
+	var got []string
+	for i := 0; i < 3; i++ {
+		msg, err := r.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage: %s", err)
+		}
+		got = append(got, msg.Short)
+	}
+	sort.Strings(got)
+	if len(got) != 3 || got[0] != "first" || got[1] != "second" || got[2] != "third" {
+		t.Errorf("expected the messages of the 3 clients, got %v", got)
+	}
+}
+
+func TestTCPReaderInvalidFrame(t *testing.T) {
+	r, err := NewTCPReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewTCPReader: %s", err)
+	}
+
+	conn, err := net.Dial("tcp", r.Addr())
+	if err != nil {
+		t.Fatalf("Dial: %s", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("not gelf\x00"))
+	if _, err := r.ReadMessage(); err == nil {
+		t.Error("expected an error for an invalid frame")
+	}
+
+	r.Close()
+	if _, err := r.ReadMessage(); err != ErrReaderClosed {
+		t.Errorf("expected ErrReaderClosed after Close, got %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package graylog
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	minReconnectBackoff = 100 * time.Millisecond
+	maxReconnectBackoff = 30 * time.Second
+)
+
+// TCPWriter implements the GELFWriter interface, and is used to send
+// messages to a Graylog GELF TCP input. Messages are sent uncompressed,
+// each one terminated by a null byte, over a persistent connection, which
+// is a TLS one for the writers created by NewTLSWriter.
+//
+// When the connection is broken, the writer connects again, waiting 100ms
+// after the first failed attempt and doubling the delay after each one, up
+// to 30s. The message is then sent on the new connection. A connection
+// closed by the peer is noticed before writing, since writes on it may
+// still succeed locally and the message would be lost. This check is not
+// done on TLS connections, nor on Windows.
+type TCPWriter struct {
+	// ReconnectTimeout is how long the writer keeps trying to connect again
+	// before returning an error. Zero means forever.
+	ReconnectTimeout time.Duration
+
+	mu         sync.Mutex
+	conn       net.Conn
+	dial       func() (net.Conn, error)
+	byteSlices ByteSliceEncoding
+	strict     bool // StrictMode when the writer was created
+}
+
+// newTCPWriter opens a connection to addr, a "tcp://host:port" address,
+// with ctx. Reconnections don't use it.
+func newTCPWriter(ctx context.Context, addr string, cfg *writerConfig) (*TCPWriter, error) {
+	addr = strings.TrimPrefix(addr, "tcp://")
+	w := &TCPWriter{
+		dial:       func() (net.Conn, error) { return net.Dial("tcp", addr) },
+		byteSlices: cfg.byteSlices,
+		strict:     StrictMode,
+	}
+
+	var err error
+	if w.conn, err = new(net.Dialer).DialContext(ctx, "tcp", addr); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// NewTLSWriter returns a writer sending messages to the GELF TCP input at
+// addr over TLS, framed like the TCPWriter ones. Certificates are verified
+// according to tlsConfig, and verification errors are returned.
+func NewTLSWriter(addr string, tlsConfig *tls.Config) (GELFWriter, error) {
+	addr = strings.TrimPrefix(addr, "tcp://")
+	w := &TCPWriter{
+		dial: func() (net.Conn, error) {
+			return tls.DialWithDialer(new(net.Dialer), "tcp", addr, tlsConfig)
+		},
+		strict: StrictMode,
+	}
+
+	var err error
+	if w.conn, err = w.dial(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// WriteMessage sends the message, followed by the null byte delimiter.
+func (w *TCPWriter) WriteMessage(m *Message) (err error) {
+	if err = checkFacility(m, w.strict); err != nil {
+		return
+	}
+	mBytes, err := json.Marshal(encodeByteSlices(m, w.byteSlices))
+	if err != nil {
+		return
+	}
+	mBytes = append(mBytes, 0)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// a nil connection means the last reconnection timed out
+	if w.conn == nil || peerClosed(w.conn) {
+		if err = w.reconnect(); err != nil {
+			return
+		}
+	}
+	if err = w.write(mBytes); isBrokenConn(err) {
+		if err = w.reconnect(); err != nil {
+			return
+		}
+		err = w.write(mBytes)
+	}
+	return
+}
+
+// Close closes the connection
+func (w *TCPWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.conn == nil {
+		return nil
+	}
+	return w.conn.Close()
+}
+
+// write sends p on the connection. w.mu must be held.
+func (w *TCPWriter) write(p []byte) error {
+	// messages larger than the socket send buffer may need several writes
+	for len(p) > 0 {
+		n, err := w.conn.Write(p)
+		if err != nil {
+			return err
+		}
+		p = p[n:]
+	}
+	return nil
+}
+
+// reconnect replaces the broken connection, retrying with a truncated
+// exponential back-off until ReconnectTimeout. When it times out, w.conn
+// is left nil so that the next write tries again. w.mu must be held.
+func (w *TCPWriter) reconnect() error {
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+
+	var deadline time.Time
+	if w.ReconnectTimeout > 0 {
+		deadline = time.Now().Add(w.ReconnectTimeout)
+	}
+
+	backoff := minReconnectBackoff
+	for {
+		conn, err := w.dial()
+		if err == nil {
+			w.conn = conn
+			return nil
+		}
+		if !deadline.IsZero() && time.Now().Add(backoff).After(deadline) {
+			return err
+		}
+
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxReconnectBackoff {
+			backoff = maxReconnectBackoff
+		}
+	}
+}
+
+// isBrokenConn reports whether err means the peer closed the connection
+func isBrokenConn(err error) bool {
+	return errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, io.EOF)
+}
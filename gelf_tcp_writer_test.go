@@ -0,0 +1,315 @@
+package graylog
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tcpServer accepts a single connection and sends the null byte delimited
+// messages it receives on the returned channel
+func tcpServer(t *testing.T) (net.Listener, chan *Message) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %s", err)
+	}
+	return l, serveGELF(t, l)
+}
+
+func serveGELF(t *testing.T, l net.Listener) chan *Message {
+	messages := make(chan *Message, 16)
+	go func() {
+		defer close(messages)
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		for {
+			frame, err := r.ReadBytes(0)
+			if err != nil {
+				return
+			}
+			var m Message
+			if err := json.Unmarshal(frame[:len(frame)-1], &m); err != nil {
+				t.Errorf("Unmarshal: %s", err)
+				return
+			}
+			messages <- &m
+		}
+	}()
+	return messages
+}
+
+func TestTCPWriter(t *testing.T) {
+	l, messages := tcpServer(t)
+	defer l.Close()
+
+	w, err := NewWriter("tcp://" + l.Addr().String())
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	if _, ok := w.(*TCPWriter); !ok {
+		t.Fatalf("NewWriter: expected a *TCPWriter, got %T", w)
+	}
+
+	// larger than the socket send buffer
+	large := Logf(SyslogInformational, "large")
+	large.Full = strings.Repeat("x", 4<<20)
+
+	var wg sync.WaitGroup
+	for _, m := range []*Message{Logf(SyslogInformational, "small"), large} {
+		wg.Add(1)
+		go func(m *Message) {
+			defer wg.Done()
+			if err := w.WriteMessage(m); err != nil {
+				t.Errorf("WriteMessage: %s", err)
+			}
+		}(m)
+	}
+
+	received := map[string]int{}
+	for i := 0; i < 2; i++ {
+		m := <-messages
+		if m == nil {
+			t.Fatal("connection closed before all messages were received")
+		}
+		received[m.Short] = len(m.Full)
+	}
+	wg.Wait()
+
+	if _, ok := received["small"]; !ok {
+		t.Errorf("expected the small message, got %v", received)
+	}
+	if received["large"] != 4<<20 {
+		t.Errorf("expected the whole large message, got %d bytes", received["large"])
+	}
+
+	w.(*TCPWriter).Close()
+	if _, ok := <-messages; ok {
+		t.Error("expected the connection to be closed")
+	}
+}
+
+func TestTLSWriter(t *testing.T) {
+	// borrow the test certificate of httptest, valid for 127.0.0.1
+	srv := httptest.NewTLSServer(nil)
+	defer srv.Close()
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: srv.TLS.Certificates})
+	if err != nil {
+		t.Fatalf("Listen: %s", err)
+	}
+	defer l.Close()
+	messages := serveGELF(t, l)
+
+	// unknown authority
+	if _, err := NewTLSWriter(l.Addr().String(), &tls.Config{}); err == nil {
+		t.Fatal("NewTLSWriter should fail to verify the certificate")
+	}
+	<-messages // the failed handshake closes the server
+
+	l, err = tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: srv.TLS.Certificates})
+	if err != nil {
+		t.Fatalf("Listen: %s", err)
+	}
+	defer l.Close()
+	messages = serveGELF(t, l)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	w, err := NewTLSWriter(l.Addr().String(), &tls.Config{RootCAs: roots})
+	if err != nil {
+		t.Fatalf("NewTLSWriter: %s", err)
+	}
+	defer w.(*TCPWriter).Close()
+
+	if err := w.WriteMessage(Logf(SyslogInformational, "encrypted")); err != nil {
+		t.Fatalf("WriteMessage: %s", err)
+	}
+	if m := <-messages; m == nil || m.Short != "encrypted" {
+		t.Errorf("expected the encrypted message, got %v", m)
+	}
+}
+
+func TestTCPWriterReconnect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %s", err)
+	}
+	addr := l.Addr().String()
+
+	// the first server goes away after the first message
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		bufio.NewReader(conn).ReadBytes(0)
+		conn.Close()
+	}()
+
+	w, err := NewWriter("tcp://" + addr)
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	defer w.(*TCPWriter).Close()
+	w.(*TCPWriter).ReconnectTimeout = 5 * time.Second
+
+	if err := w.WriteMessage(Logf(SyslogInformational, "first")); err != nil {
+		t.Fatalf("WriteMessage: %s", err)
+	}
+	<-gone
+
+	// and comes back after the first reconnection attempts failed
+	restarted := make(chan chan *Message, 1)
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			t.Errorf("Listen: %s", err)
+			close(restarted)
+			return
+		}
+		restarted <- serveGELF(t, l)
+	}()
+
+	// the first writes may succeed locally, before the peer resets the
+	// connection
+	deadline := time.Now().Add(2 * time.Second)
+	for i := 0; time.Now().Before(deadline); i++ {
+		if err := w.WriteMessage(Logf(SyslogInformational, "message %d", i)); err != nil {
+			t.Fatalf("WriteMessage: %s", err)
+		}
+		select {
+		case messages := <-restarted:
+			if messages == nil {
+				return
+			}
+			if m := <-messages; m == nil || !strings.HasPrefix(m.Short, "message ") {
+				t.Errorf("expected a message after reconnection, got %v", m)
+			}
+			return
+		default:
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	t.Error("the writer didn't reconnect")
+}
+
+func TestTCPWriterPeerClosed(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %s", err)
+	}
+	defer l.Close()
+
+	// the server closes each connection after its first message
+	messages := make(chan string, 2)
+	closed := make(chan struct{}, 2)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			frame, err := bufio.NewReader(conn).ReadBytes(0)
+			conn.Close()
+			if err == nil {
+				var m Message
+				if err := json.Unmarshal(frame[:len(frame)-1], &m); err != nil {
+					t.Errorf("Unmarshal: %s", err)
+				}
+				messages <- m.Short
+			}
+			closed <- struct{}{}
+		}
+	}()
+
+	w, err := NewWriter("tcp://" + l.Addr().String())
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	defer w.(*TCPWriter).Close()
+	w.(*TCPWriter).ReconnectTimeout = 5 * time.Second
+
+	for _, short := range []string{"first", "second"} {
+		if err := w.WriteMessage(Logf(SyslogInformational, short)); err != nil {
+			t.Fatalf("WriteMessage: %s", err)
+		}
+		select {
+		case <-closed:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: the server didn't receive the message", short)
+		}
+	}
+
+	for _, expected := range []string{"first", "second"} {
+		if got := <-messages; got != expected {
+			t.Errorf("expected %q, got %q", expected, got)
+		}
+	}
+}
+
+func TestTCPWriterReconnectTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %s", err)
+	}
+	addr := l.Addr().String()
+
+	// the server goes away after the first message
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		bufio.NewReader(conn).ReadBytes(0)
+		conn.Close()
+	}()
+
+	w, err := NewWriter("tcp://" + addr)
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	defer w.(*TCPWriter).Close()
+	w.(*TCPWriter).ReconnectTimeout = 200 * time.Millisecond
+
+	if err := w.WriteMessage(Logf(SyslogInformational, "first")); err != nil {
+		t.Fatalf("WriteMessage: %s", err)
+	}
+	<-gone
+
+	if err := w.WriteMessage(Logf(SyslogInformational, "lost")); err == nil {
+		t.Fatal("WriteMessage should fail when the reconnection times out")
+	}
+
+	// the server comes back after the reconnection window expired
+	l, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("Listen: %s", err)
+	}
+	defer l.Close()
+	messages := serveGELF(t, l)
+
+	if err := w.WriteMessage(Logf(SyslogInformational, "back")); err != nil {
+		t.Fatalf("WriteMessage after the server came back: %s", err)
+	}
+	if m := <-messages; m == nil || m.Short != "back" {
+		t.Errorf("expected the message sent after the server came back, got %v", m)
+	}
+}
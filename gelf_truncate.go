@@ -0,0 +1,79 @@
+package graylog
+
+import (
+	"encoding/json"
+	"errors"
+	"unicode/utf8"
+)
+
+// TruncatedSuffix ends the fields truncated to fit in the size limits
+const TruncatedSuffix = "…[truncated]"
+
+// maxTruncations is the number of times a message is truncated again
+// when it still overflows
+const maxTruncations = 3
+
+// writeTruncated calls write with m, and with truncated copies of m as
+// long as the write fails because m is too large.
+func writeTruncated(write func(*Message) error, m *Message) error {
+	err := write(m)
+	for i := 0; i < maxTruncations; i++ {
+		excess := overflowExcess(m, err)
+		if excess <= 0 {
+			break
+		}
+		m = truncateMessage(m, excess)
+		err = write(m)
+	}
+	return err
+}
+
+// overflowExcess returns the number of bytes to remove from the JSON
+// encoding of m when err tells it is too large, 0 otherwise.
+func overflowExcess(m *Message, err error) int {
+	var tooLarge *MessageTooLargeError
+	if errors.As(err, &tooLarge) {
+		return tooLarge.Size - tooLarge.Limit
+	}
+	var chunkErr *ChunkError
+	if errors.As(err, &chunkErr) && chunkErr.Kind == TooManyChunks {
+		b, err := json.Marshal(m)
+		if err != nil {
+			return 0
+		}
+		// assuming the compression ratio stays the same once truncated
+		return len(b)*(chunkErr.TotalChunks-255)/chunkErr.TotalChunks + 1
+	}
+	return 0
+}
+
+// truncateMessage returns a copy of m, with its JSON encoding at least
+// excess bytes shorter. The full message is truncated first, then the
+// short one.
+func truncateMessage(m *Message, excess int) *Message {
+	c := m.Clone()
+	c.Full, excess = truncateField(c.Full, excess)
+	c.Short, _ = truncateField(c.Short, excess)
+	return c
+}
+
+// truncateField removes at least excess bytes from s, without splitting a
+// rune, and appends TruncatedSuffix. As JSON escaping never shortens a
+// string, its JSON encoding is at least as shorter. It returns the
+// number of bytes still to be removed.
+func truncateField(s string, excess int) (string, int) {
+	if excess <= 0 || s == "" {
+		return s, excess
+	}
+	keep := len(s) - excess - len(TruncatedSuffix)
+	if keep <= 0 {
+		if excess -= len(s); excess < 0 {
+			excess = 0
+		}
+		return "", excess
+	}
+	for !utf8.RuneStart(s[keep]) {
+		keep--
+	}
+	return s[:keep] + TruncatedSuffix, 0
+}
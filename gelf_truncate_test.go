@@ -0,0 +1,102 @@
+package graylog
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestTruncateField(t *testing.T) {
+	s := strings.Repeat("é", 100) // 200 bytes
+	for excess := 1; excess < len(s)-len(TruncatedSuffix); excess++ {
+		got, left := truncateField(s, excess)
+		if left != 0 || !utf8.ValidString(got) || !strings.HasSuffix(got, TruncatedSuffix) {
+			t.Fatalf("truncateField(%d): unexpected %q, %d", excess, got, left)
+		}
+		if len(s)-len(got) < excess {
+			t.Fatalf("truncateField(%d): expected at least %d bytes removed, got %d", excess, excess, len(s)-len(got))
+		}
+	}
+	if got, left := truncateField("short", 100); got != "" || left != 95 {
+		t.Errorf("expected the field to be emptied, got %q, %d", got, left)
+	}
+	if got, left := truncateField("unchanged", 0); got != "unchanged" || left != 0 {
+		t.Errorf("expected the field to be unchanged, got %q, %d", got, left)
+	}
+}
+
+func TestTruncateOnOverflow(t *testing.T) {
+	r, err := NewUDPReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewUDPReader: %s", err)
+	}
+	defer r.Close()
+	w, err := NewWriter(r.Addr())
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	uw := w.(*UDPWriter)
+	uw.TruncateOnOverflow = true
+
+	m := Logf(SyslogError, "stack trace")
+	m.Full = strings.Repeat("ü", 1000)
+	uw.MaxMessageBytes = 1024
+	if err := uw.WriteMessage(m); err != nil {
+		t.Fatalf("WriteMessage: %s", err)
+	}
+	msg, err := r.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+	if b, _ := json.Marshal(msg); len(b) > 1024 || !strings.HasSuffix(msg.Full, TruncatedSuffix) || !utf8.ValidString(msg.Full) {
+		t.Errorf("expected a valid full message truncated to fit in 1024 bytes, got %d bytes: %q", len(b), msg.Full)
+	}
+	if len(m.Full) != 2000 {
+		t.Error("WriteMessage should not modify the message")
+	}
+
+	// 255 chunks of 88 bytes
+	uw.MaxMessageBytes = 0
+	uw.CompressionType = NoCompress
+	if err := uw.SetChunkSize(128); err != nil {
+		t.Fatalf("SetChunkSize: %s", err)
+	}
+	m.Full = strings.Repeat("x", 30000)
+	if err := uw.WriteMessage(m); err != nil {
+		t.Fatalf("WriteMessage: %s", err)
+	}
+	if msg, err = r.ReadMessage(); err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+	if !strings.HasSuffix(msg.Full, TruncatedSuffix) || len(msg.Full) < 20000 {
+		t.Errorf("expected the full message to be truncated to fit in 255 chunks, got %d bytes", len(msg.Full))
+	}
+}
+
+func TestHookTruncateOnOverflow(t *testing.T) {
+	r, err := NewUDPReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewUDPReader: %s", err)
+	}
+	defer r.Close()
+	hook := NewGraylogHook(r.Addr(), nil)
+	hook.Writer().(*UDPWriter).MaxMessageBytes = 2048
+	hook.TruncateOnOverflow = true
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	logger.Hooks.Add(hook)
+	logger.Error("panic\n" + strings.Repeat("goroutine 1 [running]\n", 200))
+
+	msg, err := r.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+	if msg.Short != "panic" || !strings.HasSuffix(msg.Full, TruncatedSuffix) {
+		t.Errorf("expected the full message to be truncated, got %q / %d bytes", msg.Short, len(msg.Full))
+	}
+}
@@ -0,0 +1,136 @@
+package graylog
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultChunkTimeout is the default UDPReader ChunkTimeout, the one of
+// the Graylog UDP inputs.
+const DefaultChunkTimeout = 5 * time.Second
+
+// maxChunks is the maximum number of chunks of a message sent by
+// UDPWriter. Graylog itself drops messages of more than 128 chunks.
+const maxChunks = 255
+
+// UDPReader receives GELF messages on a UDP port, for integration tests or
+// relays. Unlike Reader, it reassembles the chunks of messages sent
+// concurrently, and reads uncompressed messages.
+type UDPReader struct {
+	// ChunkTimeout is how long the chunks of an incomplete message are
+	// kept. Expired messages are dropped when the next packet arrives.
+	ChunkTimeout time.Duration
+
+	mu      sync.Mutex
+	conn    *net.UDPConn
+	pending map[string]*chunkSet
+}
+
+// chunkSet holds the chunks received for a message ID
+type chunkSet struct {
+	chunks [][]byte
+	got    int
+	first  time.Time
+}
+
+// NewUDPReader listens for GELF messages on the UDP address addr.
+func NewUDPReader(addr string) (*UDPReader, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("ResolveUDPAddr('%s'): %s", addr, err)
+	}
+
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, fmt.Errorf("ListenUDP: %s", err)
+	}
+
+	return &UDPReader{
+		ChunkTimeout: DefaultChunkTimeout,
+		conn:         conn,
+		pending:      make(map[string]*chunkSet),
+	}, nil
+}
+
+// Addr returns the address the reader listens on
+func (r *UDPReader) Addr() string {
+	return r.conn.LocalAddr().String()
+}
+
+// Close stops listening. Pending ReadMessage calls return an error.
+func (r *UDPReader) Close() error {
+	return r.conn.Close()
+}
+
+// ReadMessage returns the next complete message received.
+func (r *UDPReader) ReadMessage() (*Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	buf := make([]byte, maxChunkSize)
+	for {
+		n, err := r.conn.Read(buf)
+		if err != nil {
+			return nil, fmt.Errorf("Read: %s", err)
+		}
+		r.expire(time.Now())
+
+		p := buf[:n]
+		if !bytes.HasPrefix(p, magicChunked) {
+			return decodeMessage(append([]byte(nil), p...))
+		}
+		payload, err := r.addChunk(p)
+		if err != nil {
+			return nil, err
+		}
+		if payload != nil {
+			return decodeMessage(payload)
+		}
+	}
+}
+
+// addChunk stores the chunk p, and returns the reassembled payload once
+// all the chunks of its message are received. r.mu must be held.
+func (r *UDPReader) addChunk(p []byte) ([]byte, error) {
+	if len(p) < chunkedHeaderLen {
+		return nil, fmt.Errorf("short chunk: %d bytes", len(p))
+	}
+	id, seq, total := string(p[2:2+8]), int(p[2+8]), int(p[2+8+1])
+	if total == 0 || total > maxChunks || seq >= total {
+		return nil, fmt.Errorf("invalid chunk %d of %d", seq, total)
+	}
+
+	set, ok := r.pending[id]
+	if !ok {
+		set = &chunkSet{chunks: make([][]byte, total), first: time.Now()}
+		r.pending[id] = set
+	} else if len(set.chunks) != total {
+		return nil, fmt.Errorf("invalid chunk %d of %d: expected %d chunks", seq, total, len(set.chunks))
+	}
+	if set.chunks[seq] == nil {
+		set.chunks[seq] = append([]byte(nil), p[chunkedHeaderLen:]...)
+		set.got++
+	}
+	if set.got < total {
+		return nil, nil
+	}
+
+	delete(r.pending, id)
+	return bytes.Join(set.chunks, nil), nil
+}
+
+// expire drops the incomplete messages older than ChunkTimeout. r.mu must
+// be held.
+func (r *UDPReader) expire(now time.Time) {
+	if r.ChunkTimeout <= 0 {
+		return
+	}
+	for id, set := range r.pending {
+		if now.Sub(set.first) > r.ChunkTimeout {
+			delete(r.pending, id)
+		}
+	}
+}
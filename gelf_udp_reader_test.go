@@ -0,0 +1,132 @@
+package graylog
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+// gelfChunks splits the JSON encoding of m in n chunks of message ID id
+func gelfChunks(t *testing.T, id byte, m *Message, n int) [][]byte {
+	payload, err := json.Marshal(m)
+	if err != nil {
+		t.Fatalf("Marshal: %s", err)
+	}
+	size := len(payload)/n + 1
+	var chunks [][]byte
+	for seq := 0; seq < n; seq++ {
+		end := (seq + 1) * size
+		if end > len(payload) {
+			end = len(payload)
+		}
+		header := append(append([]byte{}, magicChunked...), id, 0, 0, 0, 0, 0, 0, 0, byte(seq), byte(n))
+		chunks = append(chunks, append(header, payload[seq*size:end]...))
+	}
+	return chunks
+}
+
+func udpReaderConn(t *testing.T) (*UDPReader, net.Conn) {
+	r, err := NewUDPReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewUDPReader: %s", err)
+	}
+	conn, err := net.Dial("udp", r.Addr())
+	if err != nil {
+		t.Fatalf("Dial: %s", err)
+	}
+	return r, conn
+}
+
+func TestUDPReaderUncompressed(t *testing.T) {
+	r, _ := udpReaderConn(t)
+	defer r.Close()
+
+	w, err := NewWriter(r.Addr())
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	w.(*UDPWriter).CompressionType = NoCompress
+	if err := w.WriteMessage(Logf(6, "plain")); err != nil {
+		t.Fatalf("WriteMessage: %s", err)
+	}
+
+	msg, err := r.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+	if msg.Short != "plain" {
+		t.Errorf("expected short message %q, got %q", "plain", msg.Short)
+	}
+}
+
+func TestUDPReaderInterleavedChunks(t *testing.T) {
+	r, conn := udpReaderConn(t)
+	defer r.Close()
+	defer conn.Close()
+
+	a := gelfChunks(t, 'a', Logf(6, "first"), 2)
+	b := gelfChunks(t, 'b', Logf(6, "second"), 2)
+	for _, chunk := range [][]byte{a[0], b[1], a[0], a[1], b[0]} {
+		if _, err := conn.Write(chunk); err != nil {
+			t.Fatalf("Write: %s", err)
+		}
+	}
+
+	for _, expected := range []string{"first", "second"} {
+		msg, err := r.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage: %s", err)
+		}
+		if msg.Short != expected {
+			t.Errorf("expected short message %q, got %q", expected, msg.Short)
+		}
+	}
+}
+
+func TestUDPReaderChunkTimeout(t *testing.T) {
+	r, conn := udpReaderConn(t)
+	defer r.Close()
+	defer conn.Close()
+	r.ChunkTimeout = 10 * time.Millisecond
+
+	// chunks are timed when read, so read while sending
+	var msg *Message
+	var err error
+	done := make(chan struct{})
+	go func() {
+		msg, err = r.ReadMessage()
+		close(done)
+	}()
+
+	chunks := gelfChunks(t, 'a', Logf(6, "expired"), 2)
+	conn.Write(chunks[0])
+	time.Sleep(50 * time.Millisecond)
+	conn.Write(chunks[1])
+	complete := gelfChunks(t, 'b', Logf(6, "complete"), 1)
+	conn.Write(complete[0])
+
+	<-done
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+	if msg.Short != "complete" {
+		t.Errorf("expected the expired chunks to be dropped, got %q", msg.Short)
+	}
+	if set := r.pending["a\x00\x00\x00\x00\x00\x00\x00"]; set == nil || set.got != 1 {
+		t.Errorf("expected only the last chunk of the expired message to be pending, got %+v", set)
+	}
+}
+
+func TestUDPReaderInvalidChunk(t *testing.T) {
+	r, conn := udpReaderConn(t)
+	defer r.Close()
+	defer conn.Close()
+
+	chunk := gelfChunks(t, 'a', Logf(6, "invalid"), 1)[0]
+	chunk[10] = 3 // sequence number above the count
+	conn.Write(chunk)
+	if _, err := r.ReadMessage(); err == nil {
+		t.Error("expected an error for an invalid chunk")
+	}
+}
@@ -9,33 +9,98 @@ import (
 	"compress/flate"
 	"compress/gzip"
 	"compress/zlib"
+	"context"
 	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net"
 	"net/http"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
 )
 
 type GELFWriter interface {
 	WriteMessage(m *Message) (err error)
 }
 
+// GELFCloser is implemented by the writers holding resources, like
+// network connections, to be released when they are no longer used.
+type GELFCloser interface {
+	GELFWriter
+	io.Closer
+}
+
+// ConfigurableWriter is implemented by the writers whose compression can
+// be configured
+type ConfigurableWriter interface {
+	SetCompression(ct CompressType, level int)
+}
+
 // UDPWriter implements io.Writer and is used to send both discrete
 // messages to a graylog2 server, or data from a stream-oriented
 // interface (like the functions in log).
 type UDPWriter struct {
+	lastWrite        int64 // UnixNano, first for 64-bit alignment of atomic ops
 	mu               sync.Mutex
 	conn             net.Conn
 	hostname         string
 	Facility         string // defaults to current process name
 	CompressionLevel int    // one of the consts from compress/flate
 	CompressionType  CompressType
+	GELFVersion      string // version sent by Write, defaults to graylog.GELFVersion
+
+	// FacilityFunc, when set, is called for each message without facility
+	// to set it, for instance from the tenant of the current request.
+	// Write uses it instead of Facility.
+	FacilityFunc func() string
+
+	// MaxRetransmissions is the number of times the chunks of a chunked
+	// message are sent again, to make up for packet loss on unreliable
+	// networks. Messages sent in a single datagram are never sent twice.
+	MaxRetransmissions int
+	RetransmitDelay    time.Duration // pause before each retransmission
+
+	// Additional fields missing the "_" prefix required by GELF are
+	// renamed by WriteMessage. With StrictFieldNames, WriteMessage fails
+	// instead, and with StripInvalidExtra, the fields are dropped.
+	StrictFieldNames  bool
+	StripInvalidExtra bool
+
+	// MaxReconnectAttempts is the number of times the connection is dialed
+	// again when a write fails with a network error, before giving up.
+	MaxReconnectAttempts int
+	ReconnectDelay       time.Duration // pause before each reconnection
+
+	WriteTimeout time.Duration // max duration of each write, zero for none
+
+	// MaxMessageBytes is the max size of the JSON encoded messages, checked
+	// before compression, zero for none. Larger messages are not sent,
+	// WriteMessage returns a *MessageTooLargeError instead.
+	MaxMessageBytes int
+	// TruncateOnOverflow makes WriteMessage truncate the full message,
+	// then the short one, of messages larger than MaxMessageBytes or than
+	// the 255 chunks limit, instead of failing.
+	TruncateOnOverflow bool
+
+	addr        string  // address the writer dials
+	chunkSize   int     // datagram size of chunks, header included
+	strict      bool    // StrictMode when the writer was created
+	lastChunkID [8]byte // message id of the last chunked write
+	byteSlices  ByteSliceEncoding
+	adaptive    bool // pick the compression of each message with BestFor
+	validators  []ExtraValidator
+	redactor    Redactor
 
 	zw                 writerCloserResetter
 	zwCompressionLevel int
@@ -50,8 +115,73 @@ const (
 	CompressGzip CompressType = iota
 	CompressZlib
 	NoCompress
+	// CompressSnappy uses the snappy framing format. Graylog has no
+	// snappy support out of the box: the receiving input must be able to
+	// decompress snappy streams.
+	CompressSnappy
+	// CompressZstd uses zstd, at the level given by CompressionLevel on
+	// the zstd scale. Graylog has no zstd support out of the box either:
+	// the receiving input must decompress the payloads it recognizes by
+	// the zstd magic number.
+	CompressZstd
 )
 
+// BestFor returns the compression worth using for a message of
+// messageSize bytes: none below 512 bytes, where the compression overhead
+// exceeds the gain, gzip up to 100KB, and zstd above. The receiver is not
+// used.
+func (ct CompressType) BestFor(messageSize int) CompressType {
+	switch {
+	case messageSize < 512:
+		return NoCompress
+	case messageSize <= 100*1024:
+		return CompressGzip
+	default:
+		return CompressZstd
+	}
+}
+
+// compressionBenchmarkRuns is the number of times BenchmarkCompression
+// compresses the sample with each codec.
+const compressionBenchmarkRuns = 100
+
+// BenchmarkCompression compresses sample with gzip, zlib, snappy and zstd,
+// at their fastest level, and returns the one saving the most bytes per
+// millisecond, to be set as the CompressionType of a writer. sample should
+// look like the messages sent, a marshaled 1KB message for instance.
+// NoCompress is returned when no codec makes sample smaller. Each codec is
+// timed over 100 runs, so the call takes a few milliseconds.
+func BenchmarkCompression(sample []byte) CompressType {
+	best, bestScore := NoCompress, 0.0
+	for _, ct := range []CompressType{CompressGzip, CompressZlib, CompressSnappy, CompressZstd} {
+		var buf bytes.Buffer
+		zw, err := newCompressor(ct, flate.BestSpeed, &buf)
+		if err != nil {
+			continue
+		}
+		start := time.Now()
+		for i := 0; i < compressionBenchmarkRuns; i++ {
+			buf.Reset()
+			zw.Reset(&buf)
+			if _, err = zw.Write(sample); err == nil {
+				err = zw.Close()
+			}
+			if err != nil {
+				break
+			}
+		}
+		if err != nil {
+			continue
+		}
+		ms := float64(time.Since(start)) / float64(time.Millisecond)
+		saved := float64((len(sample) - buf.Len()) * compressionBenchmarkRuns)
+		if score := saved / ms; saved > 0 && score > bestScore {
+			best, bestScore = ct, score
+		}
+	}
+	return best
+}
+
 // Message represents the contents of the GELF message.  It is gzipped
 // before sending.
 type Message struct {
@@ -69,10 +199,16 @@ type Message struct {
 
 type innerMessage Message //against circular (Un)MarshalJSON
 
+// GELFVersion is the version of the GELF specification implemented, sent
+// by default.
+const GELFVersion = "1.1"
+
 // Used to control GELF chunking.  Should be less than (MTU - len(UDP
 // header)).
 //
-// TODO: generate dynamically using Path MTU Discovery?
+// ChunkSize is the default, see WithChunkSize and UDPWriter.SetChunkSize
+// to change it. Chunk sizes are the size of the UDP datagrams, GELF chunk
+// header included.
 const (
 	ChunkSize        = 1420
 	chunkedHeaderLen = 12
@@ -83,58 +219,276 @@ var (
 	magicChunked = []byte{0x1e, 0x0f}
 	magicZlib    = []byte{0x78}
 	magicGzip    = []byte{0x1f, 0x8b}
+	magicSnappy  = []byte{0xff, 0x06, 0x00, 0x00, 's', 'N', 'a', 'P', 'p', 'Y'}
+	magicZstd    = []byte{0x28, 0xb5, 0x2f, 0xfd}
 )
 
-// numChunks returns the number of GELF chunks necessary to transmit
-// the given compressed buffer.
-func numChunks(b []byte) int {
+// minChunkSize and maxChunkSize bound the chunk size, maxChunkSize being
+// the largest UDP payload over IPv4
+const (
+	minChunkSize = 100
+	maxChunkSize = 65507
+)
+
+// Header sizes of the UDP datagrams, to get the chunk size for an MTU
+const (
+	udpHeaderLen  = 8
+	ipv4HeaderLen = 20
+	ipv6HeaderLen = 40
+)
+
+// datagramOverhead returns the size of the IP and UDP headers of a datagram
+func datagramOverhead(ipv6 bool) int {
+	if ipv6 {
+		return ipv6HeaderLen + udpHeaderLen
+	}
+	return ipv4HeaderLen + udpHeaderLen
+}
+
+// numChunks returns the number of GELF chunks of chunkSize bytes
+// necessary to transmit the given compressed buffer.
+func numChunks(b []byte, chunkSize int) int {
 	lenB := len(b)
-	if lenB <= ChunkSize {
+	if lenB <= chunkSize {
 		return 1
 	}
-	return len(b)/chunkedDataLen + 1
+	return len(b)/(chunkSize-chunkedHeaderLen) + 1
 }
 
 // NewWriter returns a new GELFWriter. This writer can be used to send the
 // output of the standard Go log functions to a central GELF server by
 // passing it to log.SetOutput()
-func NewWriter(addr string) (GELFWriter, error) {
+//
+// addr is a "host:port" UDP address, a "tcp://host:port" TCP address, or an
+// "http(s)://" URL. The options only supported by UDP writers are rejected
+// for TCP and HTTP addresses.
+func NewWriter(addr string, opts ...WriterOption) (GELFWriter, error) {
+	return DialContext(context.Background(), addr, opts...)
+}
+
+// DialContext is like NewWriter, but connects to addr with ctx, to give up
+// on slow DNS resolutions or connection setups once ctx is done. The
+// context is only used for the initial connection: writes are bounded by
+// WriteTimeout, and TCP writers reconnect without it. HTTP writers have no
+// initial connection and ignore it.
+func DialContext(ctx context.Context, addr string, opts ...WriterOption) (GELFWriter, error) {
+	cfg, err := newWriterConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	tcp := strings.HasPrefix(addr, "tcp://")
+	if (tcp || strings.HasPrefix(addr, "http")) && len(cfg.udpOnly) > 0 {
+		return nil, fmt.Errorf("%s: only supported by UDP writers", strings.Join(cfg.udpOnly, ", "))
+	}
 	if strings.HasPrefix(addr, "http") {
-		return newHTTPWriter(addr)
+		return newHTTPWriter(addr, cfg)
+	}
+	if tcp {
+		return newTCPWriter(ctx, addr, cfg)
 	}
 
-	return newUDPWriter(addr)
+	return newUDPWriter(ctx, addr, cfg)
 }
 
-func newHTTPWriter(addr string) (GELFWriter, error) {
+// NewHTTPWriter returns a writer sending messages to the Graylog HTTP
+// input at addr with client. When client is nil, the client NewWriter
+// would create is used.
+func NewHTTPWriter(addr string, client *http.Client) (GELFWriter, error) {
+	if client == nil {
+		return newHTTPWriter(addr, new(writerConfig))
+	}
+	return &HTTPWriter{
+		CompressionType: NoCompress,
+		HTTPClient:      client,
+		Addr:            addr,
+		stop:            make(chan struct{}),
+		strict:          StrictMode,
+	}, nil
+}
+
+func newHTTPWriter(addr string, cfg *writerConfig) (GELFWriter, error) {
 	httpClient := &http.Client{
-		Transport: &http.Transport{},
-		Timeout:   10 * time.Second,
+		Transport: &http.Transport{
+			Proxy:               cfg.httpProxy,
+			MaxIdleConnsPerHost: cfg.httpMaxIdleConnsPerHost,
+			MaxConnsPerHost:     cfg.httpMaxConnsPerHost,
+			IdleConnTimeout:     cfg.httpIdleConnTimeout,
+		},
+		Timeout: 10 * time.Second,
+	}
+	if cfg.writeTimeout > 0 {
+		httpClient.Timeout = cfg.writeTimeout
 	}
 
-	return HTTPWriter{
-		httpClient: httpClient,
-		addr:       addr,
-	}, nil
+	w := &HTTPWriter{
+		WriteTimeout:    cfg.writeTimeout,
+		CompressionType: NoCompress,
+		HTTPClient:      httpClient,
+		Addr:            addr,
+		stop:            make(chan struct{}),
+		strict:          StrictMode,
+		byteSlices:      cfg.byteSlices,
+		errorLogger:     cfg.httpErrorLogger,
+	}
+	if cfg.httpKeepAlive > 0 {
+		go w.keepAlive(cfg.httpKeepAlive)
+	}
+
+	return w, nil
 }
 
-func newUDPWriter(addr string) (GELFWriter, error) {
+// NewUDPWriter returns a writer sending messages to the Graylog UDP input
+// at the "host:port" address addr, configured by opts:
+//
+//	w, err := graylog.NewUDPWriter("graylog:12201",
+//		graylog.WithCompressionLevel(flate.BestCompression),
+//		graylog.WithFacility("billing"))
+func NewUDPWriter(addr string, opts ...UDPOption) (GELFWriter, error) {
+	cfg, err := newWriterConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	return newUDPWriter(context.Background(), addr, cfg)
+}
+
+func newUDPWriter(ctx context.Context, addr string, cfg *writerConfig) (GELFWriter, error) {
 	var err error
 	w := new(UDPWriter)
+	w.CompressionType = cfg.compressionType
 	w.CompressionLevel = flate.BestSpeed
+	if cfg.compressionLevelSet {
+		w.CompressionLevel = cfg.compressionLevel
+	}
+	w.chunkSize = ChunkSize
+	if cfg.chunkSize > 0 {
+		w.chunkSize = cfg.chunkSize
+	}
 
-	if w.conn, err = net.Dial("udp", addr); err != nil {
+	if w.conn, err = new(net.Dialer).DialContext(ctx, "udp", addr); err != nil {
 		return nil, err
 	}
-	if w.hostname, err = os.Hostname(); err != nil {
-		return nil, err
+	w.addr = addr
+	if cfg.hostname != "" {
+		w.hostname = cfg.hostname
+	} else {
+		if w.hostname, err = os.Hostname(); err != nil {
+			return nil, err
+		}
+		if !cfg.rawHostname {
+			w.hostname = sanitizeHostname(w.hostname)
+		}
 	}
 
-	w.Facility = path.Base(os.Args[0])
+	w.Facility = cfg.facility
+	if w.Facility == "" {
+		w.Facility = path.Base(os.Args[0])
+	}
+	w.strict = StrictMode
+	w.byteSlices = cfg.byteSlices
+	w.WriteTimeout = cfg.writeTimeout
+	w.adaptive = cfg.adaptiveCompression
+	if w.strict {
+		if err = ValidateFacility(w.Facility); err != nil {
+			return nil, err
+		}
+	}
 
 	return w, nil
 }
 
+// MessageTooLargeError is returned by UDPWriter.WriteMessage for messages
+// larger than MaxMessageBytes. Field is the GELF name of the largest field
+// of the message, the likely culprit, and FieldSize its size.
+type MessageTooLargeError struct {
+	Size      int
+	Limit     int
+	Field     string
+	FieldSize int
+}
+
+func (e *MessageTooLargeError) Error() string {
+	return fmt.Sprintf("message too large: %d bytes, limit is %d (largest field %s: %d bytes)",
+		e.Size, e.Limit, e.Field, e.FieldSize)
+}
+
+// largestField returns the name and size of the largest string field of
+// m, or of the largest []byte additional field.
+func (m *Message) largestField() (string, int) {
+	field, size := "short_message", len(m.Short)
+	if len(m.Full) > size {
+		field, size = "full_message", len(m.Full)
+	}
+	for k, v := range m.Extra {
+		n := 0
+		switch v := v.(type) {
+		case string:
+			n = len(v)
+		case []byte:
+			n = len(v)
+		}
+		if n > size {
+			field, size = k, n
+		}
+	}
+	return field, size
+}
+
+// ChunkErrorKind tells what went wrong when sending a chunked message
+type ChunkErrorKind int
+
+const (
+	// TooManyChunks is returned for messages needing more than 255 chunks
+	TooManyChunks ChunkErrorKind = iota
+	// IDGenerationFailed is returned when no random message ID can be read
+	IDGenerationFailed
+	// ChunkWriteFailed is returned when writing a chunk fails
+	ChunkWriteFailed
+	// ShortWrite is returned when a chunk is partially written
+	ShortWrite
+)
+
+func (k ChunkErrorKind) String() string {
+	switch k {
+	case TooManyChunks:
+		return "too many chunks"
+	case IDGenerationFailed:
+		return "message ID generation failed"
+	case ChunkWriteFailed:
+		return "chunk write failed"
+	case ShortWrite:
+		return "short write"
+	default:
+		return fmt.Sprintf("ChunkErrorKind(%d)", int(k))
+	}
+}
+
+// ChunkError is returned by UDPWriter.WriteMessage when a chunked message
+// can't be sent. ChunkIndex is the index of the failed chunk, for
+// ChunkWriteFailed and ShortWrite errors.
+type ChunkError struct {
+	Kind        ChunkErrorKind
+	ChunkIndex  int
+	TotalChunks int
+	Err         error
+}
+
+func (e *ChunkError) Error() string {
+	switch e.Kind {
+	case TooManyChunks:
+		return fmt.Sprintf("msg too large, would need %d chunks", e.TotalChunks)
+	case IDGenerationFailed:
+		return fmt.Sprintf("rand.Reader: %s", e.Err)
+	default:
+		return fmt.Sprintf("%s (chunk %d/%d): %s", e.Kind, e.ChunkIndex, e.TotalChunks, e.Err)
+	}
+}
+
+// Unwrap returns the underlying error, if any
+func (e *ChunkError) Unwrap() error {
+	return e.Err
+}
+
 // writes the gzip compressed byte array to the connection as a series
 // of GELF chunked messages.  The header format is documented at
 // https://github.com/Graylog2/graylog2-docs/wiki/GELF as:
@@ -142,20 +496,35 @@ func newUDPWriter(addr string) (GELFWriter, error) {
 //	2-byte magic (0x1e 0x0f), 8 byte id, 1 byte sequence id, 1 byte
 //	total, chunk-data
 func (w *UDPWriter) writeChunked(zBytes []byte) (err error) {
-	b := make([]byte, 0, ChunkSize)
-	buf := bytes.NewBuffer(b)
-	nChunksI := numChunks(zBytes)
+	nChunksI := numChunks(zBytes, w.chunkSize)
 	if nChunksI > 255 {
-		return fmt.Errorf("msg too large, would need %d chunks", nChunksI)
+		return &ChunkError{Kind: TooManyChunks, TotalChunks: nChunksI}
 	}
 	nChunks := uint8(nChunksI)
 	// use urandom to get a unique message id
 	msgId := make([]byte, 8)
-	n, err := io.ReadFull(rand.Reader, msgId)
-	if err != nil || n != 8 {
-		return fmt.Errorf("rand.Reader: %d/%s", n, err)
+	if _, err = io.ReadFull(rand.Reader, msgId); err != nil {
+		return &ChunkError{Kind: IDGenerationFailed, TotalChunks: nChunksI, Err: err}
+	}
+	copy(w.lastChunkID[:], msgId)
+
+	// Graylog drops the chunks it already received, so sending them
+	// again is safe
+	for pass := 0; pass <= w.MaxRetransmissions; pass++ {
+		if pass > 0 {
+			time.Sleep(w.RetransmitDelay)
+		}
+		if err = w.writeChunks(zBytes, msgId, nChunks); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
+// writeChunks writes each chunk of zBytes once
+func (w *UDPWriter) writeChunks(zBytes, msgId []byte, nChunks uint8) error {
+	chunkDataLen := w.chunkSize - chunkedHeaderLen
+	buf := bytes.NewBuffer(make([]byte, 0, w.chunkSize))
 	bytesLeft := len(zBytes)
 	for i := uint8(0); i < nChunks; i++ {
 		buf.Reset()
@@ -167,34 +536,35 @@ func (w *UDPWriter) writeChunked(zBytes []byte) (err error) {
 		buf.WriteByte(i)
 		buf.WriteByte(nChunks)
 		// slice out our chunk from zBytes
-		chunkLen := chunkedDataLen
+		chunkLen := chunkDataLen
 		if chunkLen > bytesLeft {
 			chunkLen = bytesLeft
 		}
-		off := int(i) * chunkedDataLen
+		off := int(i) * chunkDataLen
 		chunk := zBytes[off : off+chunkLen]
 		buf.Write(chunk)
 
 		// write this chunk, and make sure the write was good
-		n, err := w.conn.Write(buf.Bytes())
+		n, err := w.writeConn(buf.Bytes())
 		if err != nil {
-			return fmt.Errorf("Write (chunk %d/%d): %s", i,
-				nChunks, err)
+			return &ChunkError{Kind: ChunkWriteFailed, ChunkIndex: int(i), TotalChunks: int(nChunks), Err: err}
 		}
 		if n != len(buf.Bytes()) {
-			return fmt.Errorf("Write len: (chunk %d/%d) (%d/%d)",
-				i, nChunks, n, len(buf.Bytes()))
+			return &ChunkError{Kind: ShortWrite, ChunkIndex: int(i), TotalChunks: int(nChunks),
+				Err: fmt.Errorf("wrote %d/%d bytes", n, len(buf.Bytes()))}
 		}
 
 		bytesLeft -= chunkLen
 	}
 
 	if bytesLeft != 0 {
-		return fmt.Errorf("error: %d bytes left after sending", bytesLeft)
+		return &ChunkError{Kind: ShortWrite, ChunkIndex: int(nChunks), TotalChunks: int(nChunks),
+			Err: fmt.Errorf("%d bytes left after sending", bytesLeft)}
 	}
 	return nil
 }
 
+// bufferedWriter writes uncompressed payloads.
 type bufferedWriter struct {
 	buffer io.Writer
 }
@@ -216,45 +586,119 @@ type writerCloserResetter interface {
 	Reset(w io.Writer)
 }
 
+// newCompressor returns the writer compressing to dst with ct at level.
+func newCompressor(ct CompressType, level int, dst io.Writer) (writerCloserResetter, error) {
+	switch ct {
+	case CompressGzip:
+		if level == gzip.NoCompression {
+			return NewNullGzipCompressor(dst), nil
+		}
+		return gzip.NewWriterLevel(dst, level)
+	case CompressZlib:
+		return zlib.NewWriterLevel(dst, level)
+	case NoCompress:
+		return &bufferedWriter{buffer: dst}, nil
+	case CompressSnappy:
+		// snappy.Writer is already resettable, no adapter is needed
+		return snappy.NewBufferedWriter(dst), nil
+	case CompressZstd:
+		// zstd.Encoder is resettable too
+		return zstd.NewWriter(dst, zstd.WithEncoderConcurrency(1),
+			zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	default:
+		panic(fmt.Sprintf("unknown compression type %d", ct))
+	}
+}
+
+// NullGzipCompressor writes a valid gzip stream without compressing the
+// data, for Graylog inputs expecting gzip framed packets. It is used when
+// CompressionType is CompressGzip and CompressionLevel is
+// gzip.NoCompression. The framing costs a 10 bytes header, an 8 bytes
+// trailer and 5 bytes per stored block of up to 64KB.
+type NullGzipCompressor struct {
+	*gzip.Writer
+}
+
+// NewNullGzipCompressor returns a NullGzipCompressor writing to w.
+func NewNullGzipCompressor(w io.Writer) *NullGzipCompressor {
+	// NoCompression is a valid level, NewWriterLevel can't fail
+	zw, _ := gzip.NewWriterLevel(w, gzip.NoCompression)
+	return &NullGzipCompressor{zw}
+}
+
 // WriteMessage sends the specified message to the GELF server
 // specified in the call to NewWriter(). It assumes all the fields are
 // filled out appropriately. In general, clients will want to use
 // Write, rather than WriteMessage.
-func (w *UDPWriter) WriteMessage(m *Message) (err error) {
+func (w *UDPWriter) WriteMessage(m *Message) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	mBytes, err := json.Marshal(m)
+	if w.TruncateOnOverflow {
+		return writeTruncated(w.writeMessage, m)
+	}
+	return w.writeMessage(m)
+}
+
+// writeMessage sends m. w.mu must be held.
+func (w *UDPWriter) writeMessage(m *Message) (err error) {
+	if w.conn == nil {
+		return ErrWriterClosed
+	}
+
+	if m.Facility == "" && w.FacilityFunc != nil {
+		c := *m
+		c.Facility = w.FacilityFunc()
+		m = &c
+	}
+	if err = checkFacility(m, w.strict); err != nil {
+		return
+	}
+	// uncompressed payloads must be valid JSON for Graylog
+	if w.strict && w.CompressionType == NoCompress {
+		if err = checkRawExtra(m); err != nil {
+			return
+		}
+	}
+
+	if m, err = checkExtraPrefix(m, w.StrictFieldNames, w.StripInvalidExtra); err != nil {
+		return
+	}
+	if err = validateExtra(m, w.validators); err != nil {
+		return
+	}
+	m = redactExtra(m, w.redactor)
+
+	mBytes, err := json.Marshal(encodeByteSlices(m, w.byteSlices))
 	if err != nil {
 		return
 	}
+	if w.MaxMessageBytes > 0 && len(mBytes) > w.MaxMessageBytes {
+		field, size := m.largestField()
+		return &MessageTooLargeError{Size: len(mBytes), Limit: w.MaxMessageBytes, Field: field, FieldSize: size}
+	}
 
 	var zBuf bytes.Buffer
 
+	ct := w.CompressionType
+	if w.adaptive {
+		ct = ct.BestFor(len(mBytes))
+	}
+
 	// . If compression settings have changed, a new writer is required.
-	if w.zwCompressionType != w.CompressionType || w.zwCompressionLevel != w.CompressionLevel {
+	if w.zwCompressionType != ct || w.zwCompressionLevel != w.CompressionLevel {
 		w.zw = nil
 	}
 
-	switch w.CompressionType {
-	case CompressGzip:
-		if w.zw == nil {
-			w.zw, err = gzip.NewWriterLevel(&zBuf, w.CompressionLevel)
-		}
-	case CompressZlib:
-		if w.zw == nil {
-			w.zw, err = zlib.NewWriterLevel(&zBuf, w.CompressionLevel)
-		}
-	case NoCompress:
-		w.zw = &bufferedWriter{}
-	default:
-		panic(fmt.Sprintf("unknown compression type %d",
-			w.CompressionType))
+	if w.zw == nil {
+		w.zw, err = newCompressor(ct, w.CompressionLevel, &zBuf)
 	}
-
 	if err != nil {
+		w.zw = nil
 		return
 	}
+	w.zwCompressionType = ct
+	w.zwCompressionLevel = w.CompressionLevel
 
 	w.zw.Reset(&zBuf)
 
@@ -264,19 +708,85 @@ func (w *UDPWriter) WriteMessage(m *Message) (err error) {
 	w.zw.Close()
 
 	zBytes := zBuf.Bytes()
-	if numChunks(zBytes) > 1 {
-		return w.writeChunked(zBytes)
+	if numChunks(zBytes, w.chunkSize) > 1 {
+		if err = w.writeChunked(zBytes); err != nil {
+			return
+		}
+	} else {
+		w.lastChunkID = [8]byte{}
+		n, err := w.writeConn(zBytes)
+		if err != nil {
+			return err
+		}
+		if n != len(zBytes) {
+			return fmt.Errorf("bad write (%d/%d)", n, len(zBytes))
+		}
 	}
 
-	n, err := w.conn.Write(zBytes)
-	if err != nil {
-		return
+	atomic.StoreInt64(&w.lastWrite, time.Now().UnixNano())
+	return nil
+}
+
+// WriteMessageIf sends m with w only if cond is true, and returns nil right
+// away otherwise, without marshaling m. It saves branching at call sites
+// like graylog.WriteMessageIf(w, m, logger.IsLevelEnabled(logrus.DebugLevel)).
+func WriteMessageIf(w GELFWriter, m *Message, cond bool) error {
+	if !cond {
+		return nil
 	}
-	if n != len(zBytes) {
-		return fmt.Errorf("bad write (%d/%d)", n, len(zBytes))
+	return w.WriteMessage(m)
+}
+
+// writeConn writes p to the connection. If the write fails with a network
+// error, like when the socket was closed by the OS, the address is dialed
+// again and the write retried, up to MaxReconnectAttempts times. w.mu must
+// be held.
+func (w *UDPWriter) writeConn(p []byte) (n int, err error) {
+	n, err = w.writeWithDeadline(p)
+	for attempt := 0; attempt < w.MaxReconnectAttempts; attempt++ {
+		// a timeout means a slow network, not a broken socket
+		var opErr *net.OpError
+		if !errors.As(err, &opErr) || opErr.Timeout() {
+			return
+		}
+		time.Sleep(w.ReconnectDelay)
+
+		conn, dialErr := net.Dial("udp", w.addr)
+		if dialErr != nil {
+			err = dialErr
+			continue
+		}
+		w.conn.Close()
+		w.conn = conn
+		n, err = w.writeWithDeadline(p)
 	}
+	return
+}
 
-	return nil
+// writeWithDeadline writes p to the connection, within WriteTimeout if set.
+func (w *UDPWriter) writeWithDeadline(p []byte) (int, error) {
+	if w.WriteTimeout > 0 {
+		w.conn.SetWriteDeadline(time.Now().Add(w.WriteTimeout))
+		defer w.conn.SetWriteDeadline(time.Time{})
+	}
+	return w.conn.Write(p)
+}
+
+// LastSuccessfulWrite returns the time of the last message successfully
+// written, or the zero time if none was.
+func (w *UDPWriter) LastSuccessfulWrite() time.Time {
+	nsec := atomic.LoadInt64(&w.lastWrite)
+	if nsec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nsec)
+}
+
+// IsStale reports whether no message was successfully written for d. It
+// can be used as a liveness probe.
+func (w *UDPWriter) IsStale(d time.Duration) bool {
+	nsec := atomic.LoadInt64(&w.lastWrite)
+	return nsec == 0 || time.Since(time.Unix(0, nsec)) > d
 }
 
 /*
@@ -309,14 +819,24 @@ func (w *UDPWriter) Write(p []byte) (n int, err error) {
 		full = p
 	}
 
+	version := w.GELFVersion
+	if version == "" {
+		version = GELFVersion
+	}
+
+	facility := w.Facility
+	if w.FacilityFunc != nil {
+		facility = w.FacilityFunc()
+	}
+
 	m := Message{
-		Version:  "1.0",
+		Version:  version,
 		Host:     w.hostname,
 		Short:    string(short),
 		Full:     string(full),
 		TimeUnix: float64(time.Now().UnixNano()/1000000) / 1000.,
 		Level:    6, // info
-		Facility: w.Facility,
+		Facility: facility,
 		Extra:    map[string]interface{}{},
 	}
 
@@ -327,22 +847,192 @@ func (w *UDPWriter) Write(p []byte) (n int, err error) {
 	return len(p), nil
 }
 
+// LastChunkID returns the GELF message id used by the last chunked write,
+// to help debugging messages Graylog could not reassemble. It is all zeros
+// if the last message was sent in a single datagram.
+func (w *UDPWriter) LastChunkID() [8]byte {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.lastChunkID
+}
+
+// SetChunkSize sets the size of the chunks of large messages to fit in
+// packets of mtu bytes, less the IP and UDP headers, to avoid unnecessary
+// fragmentation on jumbo frame networks: mtu - 28 over IPv4, and mtu - 48
+// over IPv6. The resulting chunk size must be between 100 and 65507 bytes.
+func (w *UDPWriter) SetChunkSize(mtu int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	addr, _ := w.conn.RemoteAddr().(*net.UDPAddr)
+	ipv6 := addr != nil && addr.IP.To4() == nil
+	size := mtu - datagramOverhead(ipv6)
+	if size < minChunkSize || size > maxChunkSize {
+		return fmt.Errorf("invalid MTU %d: chunk size must be between %d and %d bytes, got %d", mtu, minChunkSize, maxChunkSize, size)
+	}
+
+	w.chunkSize = size
+	return nil
+}
+
+func validateChunkSize(size int) error {
+	if size < minChunkSize || size > maxChunkSize {
+		return fmt.Errorf("invalid chunk size %d: must be between %d and %d bytes", size, minChunkSize, maxChunkSize)
+	}
+	return nil
+}
+
+// SetCompression sets the compression type and level, implementing the
+// ConfigurableWriter interface
+func (w *UDPWriter) SetCompression(ct CompressType, level int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.CompressionType = ct
+	w.CompressionLevel = level
+}
+
+// Copy returns a new writer with the same settings, sending messages to the
+// same address, but through its own UDP socket. It is meant to give each
+// forked child process its own writer.
+func (w *UDPWriter) Copy() (*UDPWriter, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.conn == nil {
+		return nil, ErrWriterClosed
+	}
+
+	conn, err := net.Dial("udp", w.conn.RemoteAddr().String())
+	if err != nil {
+		return nil, err
+	}
+
+	return &UDPWriter{
+		conn:                 conn,
+		addr:                 w.addr,
+		chunkSize:            w.chunkSize,
+		hostname:             w.hostname,
+		Facility:             w.Facility,
+		FacilityFunc:         w.FacilityFunc,
+		CompressionLevel:     w.CompressionLevel,
+		CompressionType:      w.CompressionType,
+		GELFVersion:          w.GELFVersion,
+		MaxRetransmissions:   w.MaxRetransmissions,
+		RetransmitDelay:      w.RetransmitDelay,
+		StrictFieldNames:     w.StrictFieldNames,
+		StripInvalidExtra:    w.StripInvalidExtra,
+		MaxReconnectAttempts: w.MaxReconnectAttempts,
+		ReconnectDelay:       w.ReconnectDelay,
+		WriteTimeout:         w.WriteTimeout,
+		MaxMessageBytes:      w.MaxMessageBytes,
+		TruncateOnOverflow:   w.TruncateOnOverflow,
+		strict:               w.strict,
+		byteSlices:           w.byteSlices,
+		adaptive:             w.adaptive,
+		validators:           append([]ExtraValidator(nil), w.validators...),
+		redactor:             w.redactor,
+	}, nil
+}
+
+// AddExtraValidator registers a validator called on each additional field
+// before a message is sent. Messages with an invalid field are not sent,
+// WriteMessage returns a *ValidationError instead.
+func (w *UDPWriter) AddExtraValidator(v ExtraValidator) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.validators = append(w.validators, v)
+}
+
+// SetRedactor sets the Redactor applied to the additional fields of the
+// messages sent, nil to disable it.
+func (w *UDPWriter) SetRedactor(r Redactor) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.redactor = r
+}
+
+// SetRemoteAddr sends the next messages to addr, through a new UDP
+// connection. The write in progress, if any, completes on the old
+// connection, which is then closed. It reopens closed writers.
+func (w *UDPWriter) SetRemoteAddr(addr string) error {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	old := w.conn
+	w.conn = conn
+	w.addr = addr
+	w.mu.Unlock()
+
+	if old == nil {
+		return nil
+	}
+	return old.Close()
+}
+
+// Close closes the connection. The messages written afterwards are not
+// sent, and ErrWriterClosed is returned.
+func (w *UDPWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
+
+// Drain waits for the write in progress, if any, to complete. It returns
+// context.DeadlineExceeded if the write is still running after timeout.
+// Call Drain before closing the writer on shutdown.
+func (w *UDPWriter) Drain(timeout time.Duration) error {
+	locked := make(chan struct{})
+	go func() {
+		w.mu.Lock()
+		close(locked)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-locked:
+		w.mu.Unlock()
+		return nil
+	case <-timer.C:
+		// release the lock once the pending write is done
+		go func() {
+			<-locked
+			w.mu.Unlock()
+		}()
+		return context.DeadlineExceeded
+	}
+}
+
+// MarshalJSON encodes the message, with its additional fields at the top
+// level. It doesn't modify m, so a message can be marshaled concurrently.
 func (m *Message) MarshalJSON() ([]byte, error) {
 	var err error
 	var b, eb []byte
 
-	extra := m.Extra
-	b, err = json.Marshal((*innerMessage)(m))
-	m.Extra = extra
-	if err != nil {
+	// Extra is tagged "-", and marshaled separately
+	if b, err = json.Marshal((*innerMessage)(m)); err != nil {
 		return nil, err
 	}
 
-	if len(extra) == 0 {
+	if len(m.Extra) == 0 {
 		return b, nil
 	}
 
-	if eb, err = json.Marshal(extra); err != nil {
+	if eb, err = json.Marshal(m.Extra); err != nil {
 		return nil, err
 	}
 
@@ -351,65 +1041,319 @@ func (m *Message) MarshalJSON() ([]byte, error) {
 	return append(b, eb[1:len(eb)]...), nil
 }
 
+// UnmarshalJSON decodes a JSON message. The numbers of additional fields
+// are decoded as json.Number, so that large integers like IDs are not
+// rounded to a float64. Data after the message is an error.
 func (m *Message) UnmarshalJSON(data []byte) error {
+	dec := newMessageDecoder(bytes.NewReader(data))
+	if err := m.decode(dec); err != nil {
+		return err
+	}
+	var trailing json.RawMessage
+	if err := dec.Decode(&trailing); err != io.EOF {
+		return errors.New("unexpected data after the message")
+	}
+	return nil
+}
+
+// UnmarshalFromReader decodes the next JSON message read from r, without
+// reading the whole payload in memory first. The decoder may buffer data
+// read past the message. Like with UnmarshalJSON, the numbers of
+// additional fields are decoded as json.Number.
+func (m *Message) UnmarshalFromReader(r io.Reader) error {
+	return m.decode(newMessageDecoder(r))
+}
+
+func newMessageDecoder(r io.Reader) *json.Decoder {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	return dec
+}
+
+func (m *Message) decode(dec *json.Decoder) error {
 	i := make(map[string]interface{}, 16)
-	if err := json.Unmarshal(data, &i); err != nil {
+	if err := dec.Decode(&i); err != nil {
 		return err
 	}
-	for k, v := range i {
-		if k[0] == '_' {
+	return m.UnmarshalFromMap(i)
+}
+
+// UnmarshalFromMap sets the message fields from a decoded message, like
+// the map produced by json.Unmarshal or a YAML parser. Keys starting with
+// "_" are set as additional fields, unknown keys are ignored. Numbers can
+// be of any integer or float type.
+func (m *Message) UnmarshalFromMap(raw map[string]interface{}) error {
+	for k, v := range raw {
+		if strings.HasPrefix(k, "_") {
 			if m.Extra == nil {
 				m.Extra = make(map[string]interface{}, 1)
 			}
 			m.Extra[k] = v
 			continue
 		}
+		var err error
 		switch k {
 		case "version":
-			m.Version = v.(string)
+			m.Version, err = mapString(k, v)
 		case "host":
-			m.Host = v.(string)
+			m.Host, err = mapString(k, v)
 		case "short_message":
-			m.Short = v.(string)
+			m.Short, err = mapString(k, v)
 		case "full_message":
-			m.Full = v.(string)
+			m.Full, err = mapString(k, v)
 		case "timestamp":
-			m.TimeUnix = v.(float64)
+			m.TimeUnix, err = mapNumber(k, v)
 		case "level":
-			m.Level = int32(v.(float64))
+			var level float64
+			level, err = mapNumber(k, v)
+			m.Level = int32(level)
 		case "facility":
-			m.Facility = v.(string)
+			m.Facility, err = mapString(k, v)
 		case "file":
-			m.File = v.(string)
+			m.File, err = mapString(k, v)
 		case "line":
-			m.Line = int(v.(float64))
+			var line float64
+			line, err = mapNumber(k, v)
+			m.Line = int(line)
+		}
+		if err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+func mapString(k string, v interface{}) (string, error) {
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%s: expected a string, got %T", k, v)
+	}
+	return s, nil
+}
+
+func mapNumber(k string, v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case float32:
+		return float64(n), nil
+	case int:
+		return float64(n), nil
+	case int32:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case uint64:
+		return float64(n), nil
+	case json.Number:
+		return n.Float64()
+	default:
+		return 0, fmt.Errorf("%s: expected a number, got %T", k, v)
+	}
+}
+
 // HTTPWriter implements the GELFWriter interface, and cannot be used
-// as an io.Writer
+// as an io.Writer. Its methods have pointer receivers: use a *HTTPWriter,
+// as returned by NewWriter and NewHTTPWriter.
 type HTTPWriter struct {
-	httpClient *http.Client
-	addr       string
+	// WriteTimeout is the max duration of each request. When zero, the
+	// http.Client timeout, 10s by default, applies.
+	WriteTimeout time.Duration
+	// CompressionType is set to NoCompress by NewWriter and NewHTTPWriter.
+	// When set to CompressGzip, the zero value of CompressType, the
+	// requests body is gzipped and sent with a "Content-Encoding: gzip"
+	// header. Other types are not supported by the Graylog HTTP input and
+	// send plain JSON.
+	CompressionType CompressType
+	// MaxRetries is the number of times a request answered with a 429, 502
+	// or 503 status is retried, none by default. The retries wait for the
+	// Retry-After header delay, or RetryBackoff * 2^attempt without one.
+	// Retry-After delays longer than WriteTimeout, or 10s when it is zero,
+	// are not waited for: the error is returned instead.
+	MaxRetries   int
+	RetryBackoff time.Duration
+
+	// HTTPClient sends the requests. It can be replaced to use a custom
+	// transport, with proxy settings or client certificates. When nil,
+	// http.DefaultClient is used.
+	HTTPClient *http.Client
+	// Addr is the URL of the Graylog HTTP input.
+	Addr string
+
+	stop        chan struct{} // closed to stop the keep-alive pings
+	closeOnce   sync.Once
+	errorLogger *log.Logger // nil for the standard logger
+	byteSlices  ByteSliceEncoding
+	redactor    Redactor
+	strict      bool // StrictMode when the writer was created
 }
 
-func (h HTTPWriter) WriteMessage(m *Message) (err error) {
-	mBytes, err := json.Marshal(m)
+func (h *HTTPWriter) WriteMessage(m *Message) (err error) {
+	if err = checkFacility(m, h.strict); err != nil {
+		return
+	}
+	m = redactExtra(m, h.redactor)
+	mBytes, err := json.Marshal(encodeByteSlices(m, h.byteSlices))
 	if err != nil {
 		return
 	}
 
-	resp, err := h.httpClient.Post(h.addr, "application/json", bytes.NewBuffer(mBytes))
+	body := bytes.NewBuffer(mBytes)
+	if h.CompressionType == CompressGzip {
+		body = new(bytes.Buffer)
+		zw, _ := gzip.NewWriterLevel(body, flate.BestSpeed) // valid level
+		if _, err = zw.Write(mBytes); err == nil {
+			err = zw.Close()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	payload := body.Bytes()
+	for attempt := 0; ; attempt++ {
+		var delay time.Duration
+		delay, err = h.post(payload)
+		if err == nil || delay < 0 || attempt >= h.MaxRetries {
+			return err
+		}
+		if delay == 0 {
+			delay = h.RetryBackoff << uint(attempt)
+		} else if max := h.maxRetryAfter(); delay > max {
+			return fmt.Errorf("%s, not retried: Retry-After %s exceeds %s", err, delay, max)
+		}
+		time.Sleep(delay)
+	}
+}
+
+// post sends payload once. When the response status is retryable, post
+// returns the delay requested by its Retry-After header, or 0 if there is
+// none. Otherwise the returned delay is negative.
+func (h *HTTPWriter) post(payload []byte) (time.Duration, error) {
+	req, err := http.NewRequest("POST", h.Addr, bytes.NewReader(payload))
 	if err != nil {
-		return err
+		return -1, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.CompressionType == CompressGzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if h.WriteTimeout > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), h.WriteTimeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+
+	resp, err := h.client().Do(req)
+	if err != nil {
+		return -1, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 202 {
-		return fmt.Errorf("got code %s, expected 202", resp.Status)
+		err = fmt.Errorf("got code %s, expected 202", resp.Status)
+		switch resp.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable:
+			return retryAfter(resp.Header.Get("Retry-After")), err
+		}
+		return -1, err
 	}
 
+	return -1, nil
+}
+
+// defaultMaxRetryAfter is the longest Retry-After delay waited for by the
+// writers without WriteTimeout.
+const defaultMaxRetryAfter = 10 * time.Second
+
+// maxRetryAfter returns the longest Retry-After delay to wait for, so that
+// a server can't block the logging calls for hours.
+func (h *HTTPWriter) maxRetryAfter() time.Duration {
+	if h.WriteTimeout > 0 {
+		return h.WriteTimeout
+	}
+	return defaultMaxRetryAfter
+}
+
+// retryAfter parses a Retry-After header value, either a number of seconds
+// or an HTTP date. It returns 0 when v is empty or invalid.
+func retryAfter(v string) time.Duration {
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// SetRedactor sets the Redactor applied to the additional fields of the
+// messages sent, nil to disable it. It must not be called concurrently
+// with WriteMessage.
+func (h *HTTPWriter) SetRedactor(r Redactor) {
+	h.redactor = r
+}
+
+// Close stops the keep-alive pings, if any, and closes the idle connections
+func (h *HTTPWriter) Close() error {
+	h.closeOnce.Do(func() {
+		if h.stop != nil {
+			close(h.stop)
+		}
+	})
+	h.closeIdleConnections()
 	return nil
 }
+
+// client returns HTTPClient, or http.DefaultClient when it is nil
+func (h *HTTPWriter) client() *http.Client {
+	if h.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return h.HTTPClient
+}
+
+// closeIdleConnections closes the idle connections of HTTPClient. Those of
+// http.DefaultClient, shared with the rest of the program, are left open.
+func (h *HTTPWriter) closeIdleConnections() {
+	if h.HTTPClient == nil {
+		return
+	}
+	if t, ok := h.HTTPClient.Transport.(*http.Transport); ok {
+		t.CloseIdleConnections()
+	}
+}
+
+// keepAlive sends a ping message every interval, until the writer is
+// closed. When a ping fails, the idle connections, which may have been
+// silently closed by a load balancer or a firewall, are dropped, and the
+// error is logged to the writer error logger.
+func (h *HTTPWriter) keepAlive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-ticker.C:
+		}
+
+		ping := Logf(SyslogDebug, "keepalive").AppendExtra("_keepalive", true)
+		if err := h.WriteMessage(ping); err != nil {
+			h.logError("graylog: keep-alive ping failed: %s", err)
+			h.closeIdleConnections()
+		}
+	}
+}
+
+// logError logs a background error to the error logger of the writer.
+func (h *HTTPWriter) logError(format string, v ...interface{}) {
+	if h.errorLogger != nil {
+		h.errorLogger.Printf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
@@ -0,0 +1,114 @@
+package graylog
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// WriterGroup implements the GELFWriter interface, sending messages to a
+// primary writer, with a secondary one on standby. After failoverThreshold
+// consecutive failures of the primary writer, the secondary one is promoted
+// and the old primary is probed every RecoveryInterval until it works
+// again, to be ready for the next failover.
+type WriterGroup struct {
+	RecoveryInterval time.Duration
+
+	mu         sync.Mutex
+	primary    GELFWriter
+	secondary  GELFWriter
+	threshold  int
+	failures   int
+	recovering bool
+	stop       chan struct{}
+	closeOnce  sync.Once
+}
+
+// NewWriterGroup returns a group sending to primary, failing over to
+// secondary after failoverThreshold consecutive errors.
+func NewWriterGroup(primary, secondary GELFWriter, failoverThreshold int) *WriterGroup {
+	return &WriterGroup{
+		RecoveryInterval: 5 * time.Second,
+		primary:          primary,
+		secondary:        secondary,
+		threshold:        failoverThreshold,
+		stop:             make(chan struct{}),
+	}
+}
+
+// WriteMessage sends m to the current primary writer. When the write
+// promotes the secondary writer, m is sent to it too.
+func (g *WriterGroup) WriteMessage(m *Message) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	err := g.primary.WriteMessage(m)
+	if err == nil {
+		g.failures = 0
+		return nil
+	}
+
+	g.failures++
+	if g.failures < g.threshold || g.recovering {
+		return err
+	}
+
+	failed := g.primary
+	g.primary, g.secondary = g.secondary, g.primary
+	g.failures = 0
+	g.recovering = true
+	go g.recover(failed)
+
+	event := Logf(SyslogWarning, "graylog writer failover: %s", err).AppendExtra("_failover", true)
+	if err := g.primary.WriteMessage(event); err != nil {
+		fmt.Println(err)
+	}
+	return g.primary.WriteMessage(m)
+}
+
+// CurrentPrimary returns the writer messages are sent to
+func (g *WriterGroup) CurrentPrimary() GELFWriter {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.primary
+}
+
+// Close stops probing the failed writer, if any
+func (g *WriterGroup) Close() error {
+	g.closeOnce.Do(func() {
+		close(g.stop)
+	})
+	return nil
+}
+
+// recover probes w until a write succeeds, w being the secondary writer
+func (g *WriterGroup) recover(w GELFWriter) {
+	if probeUntilHealthy(w, g.RecoveryInterval, g.stop, func() *Message {
+		return Logf(SyslogDebug, "graylog writer recovery probe").AppendExtra("_recovery_probe", true)
+	}) {
+		g.mu.Lock()
+		g.recovering = false
+		g.mu.Unlock()
+	}
+}
+
+// probeUntilHealthy writes a message built by probe to w every interval,
+// until a write succeeds or stop is closed. It reports whether w is
+// healthy again.
+func probeUntilHealthy(w GELFWriter, interval time.Duration, stop <-chan struct{}, probe func() *Message) bool {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return false
+		case <-ticker.C:
+		}
+
+		if err := w.WriteMessage(probe()); err == nil {
+			return true
+		}
+	}
+}
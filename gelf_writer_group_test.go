@@ -0,0 +1,48 @@
+package graylog
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWriterGroup(t *testing.T) {
+	primary := &flakyWriter{failures: 3}
+	secondary := &flakyWriter{}
+	g := NewWriterGroup(primary, secondary, 2)
+	g.RecoveryInterval = 5 * time.Millisecond
+	defer g.Close()
+
+	if err := g.WriteMessage(Logf(SyslogInformational, "one")); err == nil {
+		t.Error("WriteMessage should return the primary error before failover")
+	}
+	if err := g.WriteMessage(Logf(SyslogInformational, "two")); err != nil {
+		t.Fatalf("WriteMessage: %s", err)
+	}
+	if g.CurrentPrimary() != secondary {
+		t.Fatal("the secondary writer should be promoted")
+	}
+	got := secondary.messages()
+	if len(got) != 2 || got[1] != "two" {
+		t.Errorf("secondary: expected the failover event and two, got %v", got)
+	}
+
+	// the old primary fails once more, then recovers
+	time.Sleep(50 * time.Millisecond)
+	if got := primary.messages(); !reflect.DeepEqual(got, []string{"graylog writer recovery probe"}) {
+		t.Errorf("primary: expected a single successful probe, got %v", got)
+	}
+
+	// and takes over again when the secondary fails
+	secondary.mu.Lock()
+	secondary.failures = 2
+	secondary.mu.Unlock()
+	g.WriteMessage(Logf(SyslogInformational, "three"))
+	g.WriteMessage(Logf(SyslogInformational, "four"))
+	if g.CurrentPrimary() != primary {
+		t.Error("the recovered writer should be promoted back")
+	}
+	if got := primary.messages(); got[len(got)-1] != "four" {
+		t.Errorf("primary: expected four, got %v", got)
+	}
+}
@@ -0,0 +1,278 @@
+package graylog
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// WriterOption configures a writer created by NewWriter.
+type WriterOption func(*writerConfig) error
+
+// UDPOption configures a writer created by NewUDPWriter. Options only
+// applying to HTTP writers are ignored, while NewWriter returns an error for
+// the options only applying to UDP writers, like WithChunkSize, given with
+// a TCP or HTTP address.
+type UDPOption = WriterOption
+
+// writerConfig holds the settings collected from the WriterOption values
+// passed to NewWriter, before the writer itself is built.
+type writerConfig struct {
+	facility    string
+	hostname    string
+	rawHostname bool
+	byteSlices  ByteSliceEncoding
+
+	compressionType     CompressType
+	compressionLevel    int
+	compressionLevelSet bool
+	chunkSize           int
+	udpOnly             []string // options TCP and HTTP writers don't support
+
+	httpMaxIdleConnsPerHost int
+	httpIdleConnTimeout     time.Duration
+	httpMaxConnsPerHost     int
+	httpKeepAlive           time.Duration
+	httpErrorLogger         *log.Logger
+	httpProxy               func(*http.Request) (*url.URL, error)
+	adaptiveCompression     bool
+	writeTimeout            time.Duration
+}
+
+func newWriterConfig(opts []WriterOption) (*writerConfig, error) {
+	cfg := new(writerConfig)
+	for _, opt := range opts {
+		if err := opt(cfg); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+// WithFacilityFromEnv sets the writer Facility from the environment
+// variable envVar, read when the writer is created. If the variable is
+// unset or empty, the Facility defaults to the current process name.
+func WithFacilityFromEnv(envVar string) WriterOption {
+	return WithFacilityFromEnvOrDefault(envVar, "")
+}
+
+// WithFacilityFromEnvOrDefault is like WithFacilityFromEnv, but falls back
+// to defaultFacility when the environment variable is unset or empty.
+func WithFacilityFromEnvOrDefault(envVar, defaultFacility string) WriterOption {
+	return func(cfg *writerConfig) error {
+		cfg.udpOnly = append(cfg.udpOnly, "WithFacilityFromEnv")
+		if facility := os.Getenv(envVar); facility != "" {
+			cfg.facility = facility
+		} else {
+			cfg.facility = defaultFacility
+		}
+		return nil
+	}
+}
+
+// WithFacility sets the Facility of a UDP writer, instead of the process
+// name.
+func WithFacility(facility string) WriterOption {
+	return func(cfg *writerConfig) error {
+		if facility == "" {
+			return errors.New("invalid facility: empty")
+		}
+		cfg.udpOnly = append(cfg.udpOnly, "WithFacility")
+		cfg.facility = facility
+		return nil
+	}
+}
+
+// WithHTTPMaxIdleConns sets the number of idle connections an HTTP writer
+// keeps open to the Graylog server, to reuse them under high message rates.
+func WithHTTPMaxIdleConns(n int) WriterOption {
+	return func(cfg *writerConfig) error {
+		if n < 0 {
+			return fmt.Errorf("invalid max idle connections: %d", n)
+		}
+		cfg.httpMaxIdleConnsPerHost = n
+		return nil
+	}
+}
+
+// WithHTTPIdleConnTimeout sets how long an HTTP writer keeps an idle
+// connection open before closing it.
+func WithHTTPIdleConnTimeout(d time.Duration) WriterOption {
+	return func(cfg *writerConfig) error {
+		if d < 0 {
+			return fmt.Errorf("invalid idle connection timeout: %s", d)
+		}
+		cfg.httpIdleConnTimeout = d
+		return nil
+	}
+}
+
+// WithHTTPMaxConnsPerHost limits the number of connections, idle or in
+// use, an HTTP writer opens to the Graylog server. Requests wait for a
+// connection once the limit is reached. There is no limit by default.
+func WithHTTPMaxConnsPerHost(n int) WriterOption {
+	return func(cfg *writerConfig) error {
+		if n < 0 {
+			return fmt.Errorf("invalid max connections: %d", n)
+		}
+		cfg.httpMaxConnsPerHost = n
+		return nil
+	}
+}
+
+// WithRawHostname disables the sanitization of the hostname sent by the
+// writer, for environments intentionally using non RFC 1123 compliant names.
+func WithRawHostname() WriterOption {
+	return func(cfg *writerConfig) error {
+		cfg.udpOnly = append(cfg.udpOnly, "WithRawHostname")
+		cfg.rawHostname = true
+		return nil
+	}
+}
+
+// WithHostname sets the hostname of the messages built by a UDP writer's
+// Write instead of the one returned by os.Hostname, which is the container
+// ID in containerized environments. The hostname is sent as given, without
+// sanitization.
+func WithHostname(hostname string) WriterOption {
+	return func(cfg *writerConfig) error {
+		if hostname == "" {
+			return errors.New("invalid hostname: empty")
+		}
+		if strings.IndexFunc(hostname, unicode.IsSpace) >= 0 {
+			return fmt.Errorf("invalid hostname %q: contains whitespace", hostname)
+		}
+		cfg.udpOnly = append(cfg.udpOnly, "WithHostname")
+		cfg.hostname = hostname
+		return nil
+	}
+}
+
+// WithKeepAlive makes an HTTP writer send a ping message, flagged with a
+// "_keepalive" field, every interval to keep its connections warm. When a
+// ping fails, the idle connections are closed and the error is logged, see
+// WithErrorLogger. Pings are disabled when interval is 0, the default, and
+// stopped by closing the writer.
+func WithKeepAlive(interval time.Duration) WriterOption {
+	return func(cfg *writerConfig) error {
+		if interval < 0 {
+			return fmt.Errorf("invalid keep-alive interval: %s", interval)
+		}
+		cfg.httpKeepAlive = interval
+		return nil
+	}
+}
+
+// WithErrorLogger sets the logger of the errors an HTTP writer hits in the
+// background, like failed keep-alive pings. By default, they go to the
+// standard logger of the log package.
+func WithErrorLogger(l *log.Logger) WriterOption {
+	return func(cfg *writerConfig) error {
+		cfg.httpErrorLogger = l
+		return nil
+	}
+}
+
+// WithExtraByteSliceEncoding sets how the []byte values of additional
+// fields are sent to Graylog.
+func WithExtraByteSliceEncoding(enc ByteSliceEncoding) WriterOption {
+	return func(cfg *writerConfig) error {
+		if enc < ByteSliceBase64 || enc > ByteSliceLength {
+			return fmt.Errorf("unknown byte slice encoding %d", enc)
+		}
+		cfg.byteSlices = enc
+		return nil
+	}
+}
+
+// WithHTTPProxy makes an HTTP writer send its requests through the given
+// proxy, like "http://proxy.corp:3128".
+func WithHTTPProxy(proxyURL string) WriterOption {
+	return func(cfg *writerConfig) error {
+		u, err := url.Parse(proxyURL)
+		if err != nil {
+			return fmt.Errorf("invalid proxy URL: %s", err)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid proxy URL %q: scheme and host are required", proxyURL)
+		}
+		cfg.httpProxy = http.ProxyURL(u)
+		return nil
+	}
+}
+
+// WithSystemProxy makes an HTTP writer use the proxy set by the
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+func WithSystemProxy() WriterOption {
+	return func(cfg *writerConfig) error {
+		cfg.httpProxy = http.ProxyFromEnvironment
+		return nil
+	}
+}
+
+// WithAdaptiveCompression makes a UDP writer pick the compression of each
+// message with CompressType.BestFor, instead of always using
+// CompressionType.
+func WithAdaptiveCompression() WriterOption {
+	return func(cfg *writerConfig) error {
+		cfg.udpOnly = append(cfg.udpOnly, "WithAdaptiveCompression")
+		cfg.adaptiveCompression = true
+		return nil
+	}
+}
+
+// WithWriteTimeout sets the WriteTimeout of UDP and HTTP writers.
+func WithWriteTimeout(d time.Duration) WriterOption {
+	return func(cfg *writerConfig) error {
+		if d < 0 {
+			return fmt.Errorf("invalid write timeout: %s", d)
+		}
+		cfg.writeTimeout = d
+		return nil
+	}
+}
+
+// WithCompressionType sets the CompressionType of a UDP writer, CompressGzip
+// by default.
+func WithCompressionType(ct CompressType) WriterOption {
+	return func(cfg *writerConfig) error {
+		if ct < CompressGzip || ct > CompressZstd {
+			return fmt.Errorf("unknown compression type %d", ct)
+		}
+		cfg.udpOnly = append(cfg.udpOnly, "WithCompressionType")
+		cfg.compressionType = ct
+		return nil
+	}
+}
+
+// WithCompressionLevel sets the CompressionLevel of a UDP writer,
+// flate.BestSpeed by default.
+func WithCompressionLevel(level int) WriterOption {
+	return func(cfg *writerConfig) error {
+		cfg.udpOnly = append(cfg.udpOnly, "WithCompressionLevel")
+		cfg.compressionLevel = level
+		cfg.compressionLevelSet = true
+		return nil
+	}
+}
+
+// WithChunkSize sets the size of the chunks a UDP writer splits large
+// messages into, ChunkSize by default. It is the size of the UDP
+// datagrams, GELF chunk header included, between 100 and 65507 bytes, as
+// returned by ChunkSizeForMTU. UDPWriter.SetChunkSize takes an MTU instead.
+func WithChunkSize(size int) WriterOption {
+	return func(cfg *writerConfig) error {
+		if err := validateChunkSize(size); err != nil {
+			return err
+		}
+		cfg.udpOnly = append(cfg.udpOnly, "WithChunkSize")
+		cfg.chunkSize = size
+		return nil
+	}
+}
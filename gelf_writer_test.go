@@ -0,0 +1,1637 @@
+package graylog
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWithFacilityFromEnv(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+
+	os.Setenv("GRAYLOG_TEST_FACILITY", "billing")
+	defer os.Unsetenv("GRAYLOG_TEST_FACILITY")
+
+	w, err := NewWriter(r.Addr(), WithFacilityFromEnv("GRAYLOG_TEST_FACILITY"))
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	if facility := w.(*UDPWriter).Facility; facility != "billing" {
+		t.Errorf("Facility: expected %s, got %s", "billing", facility)
+	}
+
+	w, err = NewWriter(r.Addr(), WithFacilityFromEnv("GRAYLOG_TEST_UNSET"))
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	if facility := w.(*UDPWriter).Facility; facility != path.Base(os.Args[0]) {
+		t.Errorf("Facility: expected %s, got %s", path.Base(os.Args[0]), facility)
+	}
+}
+
+func TestWithFacilityFromEnvOrDefault(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+
+	w, err := NewWriter(r.Addr(), WithFacilityFromEnvOrDefault("GRAYLOG_TEST_UNSET", "api"))
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	if facility := w.(*UDPWriter).Facility; facility != "api" {
+		t.Errorf("Facility: expected %s, got %s", "api", facility)
+	}
+}
+
+func TestNegotiateVersion(t *testing.T) {
+	addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ResolveUDPAddr: %s", err)
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		t.Fatalf("ListenUDP: %s", err)
+	}
+	defer conn.Close()
+
+	// Fake echo service advertising GELF 1.0
+	go func() {
+		buf := make([]byte, ChunkSize)
+		n, raddr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		var probe Message
+		if err := json.Unmarshal(buf[:n], &probe); err != nil || probe.Extra["_probe"] != true {
+			t.Errorf("unexpected probe: %s", buf[:n])
+			return
+		}
+		conn.WriteToUDP([]byte(`{"version":"1.0"}`), raddr)
+	}()
+
+	w, err := NewWriter(conn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	uw := w.(*UDPWriter)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := uw.NegotiateVersion(ctx); err != nil {
+		t.Fatalf("NegotiateVersion: %s", err)
+	}
+	if uw.GELFVersion != "1.0" {
+		t.Errorf("GELFVersion: expected 1.0, got %s", uw.GELFVersion)
+	}
+}
+
+func TestNegotiateVersionWithoutAnswer(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+
+	w, err := NewWriter(r.Addr())
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	uw := w.(*UDPWriter)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := uw.NegotiateVersion(ctx); err != nil {
+		t.Fatalf("NegotiateVersion: %s", err)
+	}
+	if uw.GELFVersion != "1.1" {
+		t.Errorf("GELFVersion: expected 1.1, got %s", uw.GELFVersion)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	w, err := NewWriter(r.Addr())
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	uw := w.(*UDPWriter)
+
+	const nWrites = 10
+	received := make(chan struct{}, nWrites)
+	go func() {
+		for i := 0; i < nWrites; i++ {
+			if _, err := r.ReadMessage(); err == nil {
+				received <- struct{}{}
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < nWrites; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := uw.Write([]byte("draining")); err != nil {
+				t.Errorf("Write: %s", err)
+			}
+		}()
+	}
+	if err := uw.Drain(time.Second); err != nil {
+		t.Errorf("Drain: %s", err)
+	}
+	wg.Wait()
+
+	for i := 0; i < nWrites; i++ {
+		select {
+		case <-received:
+		case <-time.After(time.Second):
+			t.Fatalf("only %d/%d messages received", i, nWrites)
+		}
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	w, err := NewWriter(r.Addr())
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	uw := w.(*UDPWriter)
+
+	// simulate a write which never completes in time
+	uw.mu.Lock()
+	if err := uw.Drain(10 * time.Millisecond); err != context.DeadlineExceeded {
+		t.Errorf("Drain: expected %s, got %v", context.DeadlineExceeded, err)
+	}
+	uw.mu.Unlock()
+
+	if err := uw.Drain(time.Second); err != nil {
+		t.Errorf("Drain: %s", err)
+	}
+}
+
+func TestWriteSnappy(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	w, err := NewWriter(r.Addr())
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	w.(*UDPWriter).CompressionType = CompressSnappy
+
+	msgData := strings.Repeat("snappy compressed message ", 200)
+	for i := 0; i < 2; i++ {
+		if _, err := w.(*UDPWriter).Write([]byte(msgData)); err != nil {
+			t.Fatalf("Write: %s", err)
+		}
+		msg, err := r.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage: %s", err)
+		}
+		if msg.Short != strings.TrimSpace(msgData) {
+			t.Errorf("msg.Short: expected %d bytes, got %d", len(msgData), len(msg.Short))
+		}
+	}
+}
+
+func TestWriteZstd(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+	w, err := NewWriter(r.Addr())
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	w.(*UDPWriter).SetCompression(CompressZstd, 3)
+
+	// the second message reuses the encoder, the third one is chunked
+	msgData := strings.Repeat("zstd compressed message ", 200)
+	for _, size := range []int{1, 1, 300} {
+		data := strings.Repeat(msgData, size)
+		if _, err := w.(*UDPWriter).Write([]byte(data)); err != nil {
+			t.Fatalf("Write: %s", err)
+		}
+		msg, err := r.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage: %s", err)
+		}
+		if msg.Short != strings.TrimSpace(data) {
+			t.Errorf("msg.Short: expected %d bytes, got %d", len(data), len(msg.Short))
+		}
+	}
+}
+
+func benchmarkCompression(b *testing.B, ct CompressType) {
+	benchmarkCompressionLevel(b, ct, flate.BestSpeed)
+}
+
+func benchmarkCompressionLevel(b *testing.B, ct CompressType, level int) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		b.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+	w, err := NewWriter(r.Addr())
+	if err != nil {
+		b.Fatalf("NewWriter: %s", err)
+	}
+	w.(*UDPWriter).CompressionType = ct
+	w.(*UDPWriter).CompressionLevel = level
+
+	m := Logf(SyslogInfoLevel, "benchmark message")
+	m.Full = strings.Repeat("a fairly repetitive stack trace line\n", 30)
+	m.AppendExtra("user", "alice").AppendExtra("request_id", "5c8d0f0e-1b8a")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := w.WriteMessage(m); err != nil {
+			b.Fatalf("WriteMessage: %s", err)
+		}
+	}
+}
+
+func BenchmarkCompressGzip(b *testing.B)   { benchmarkCompression(b, CompressGzip) }
+func BenchmarkCompressZlib(b *testing.B)   { benchmarkCompression(b, CompressZlib) }
+func BenchmarkNoCompress(b *testing.B)     { benchmarkCompression(b, NoCompress) }
+func BenchmarkCompressSnappy(b *testing.B) { benchmarkCompression(b, CompressSnappy) }
+func BenchmarkCompressZstd(b *testing.B)   { benchmarkCompression(b, CompressZstd) }
+func BenchmarkNullGzip(b *testing.B) {
+	benchmarkCompressionLevel(b, CompressGzip, gzip.NoCompression)
+}
+
+// message4KB returns a message of about 4KB once marshaled, with a log-like
+// full message: repetitive, but with varying numbers.
+func message4KB() *Message {
+	m := Logf(SyslogError, "payment failed")
+	m.AppendExtra("_user_id", "u-4242").AppendExtra("_request_id", "5c8d0f0e-1b8a-4c1e-9f7a").AppendExtra("_attempt", 3)
+	var full strings.Builder
+	for i := 0; ; i++ {
+		line := fmt.Sprintf("%04d goroutine %d [running]: billing.(*Charger).Charge(0xc%09x) /app/billing/charge.go:%d\n", i, 17+i%5, 0x420000+i*0x38, 100+i*7)
+		if b, _ := json.Marshal(m); len(b)+full.Len()+len(line) > 4096 {
+			break
+		}
+		full.WriteString(line)
+	}
+	m.Full = full.String()
+	return m
+}
+
+// BenchmarkCompression4KB compares the compression types on a 4KB message.
+// The compressed-B metric is the size of the compressed message.
+func BenchmarkCompression4KB(b *testing.B) {
+	m := message4KB()
+	mBytes, err := json.Marshal(m)
+	if err != nil {
+		b.Fatalf("json.Marshal: %s", err)
+	}
+
+	for _, bench := range []struct {
+		name string
+		ct   CompressType
+	}{
+		{"None", NoCompress},
+		{"Gzip", CompressGzip},
+		{"Zlib", CompressZlib},
+		{"Snappy", CompressSnappy},
+		{"Zstd", CompressZstd},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			var compressed bytes.Buffer
+			zw, err := newCompressor(bench.ct, flate.BestSpeed, &compressed)
+			if err != nil {
+				b.Fatalf("newCompressor: %s", err)
+			}
+			zw.Write(mBytes)
+			zw.Close()
+
+			r, err := NewReader("127.0.0.1:0")
+			if err != nil {
+				b.Fatalf("NewReader: %s", err)
+			}
+			defer r.conn.Close()
+			w, err := NewUDPWriter(r.Addr(), WithCompressionType(bench.ct), WithChunkSize(8192))
+			if err != nil {
+				b.Fatalf("NewUDPWriter: %s", err)
+			}
+			defer w.(*UDPWriter).Close()
+
+			b.SetBytes(int64(len(mBytes)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := w.WriteMessage(m); err != nil {
+					b.Fatalf("WriteMessage: %s", err)
+				}
+			}
+			b.ReportMetric(float64(compressed.Len()), "compressed-B")
+		})
+	}
+}
+
+func TestHTTPWriterConnectionOptions(t *testing.T) {
+	w, err := NewWriter("http://127.0.0.1:12201/gelf",
+		WithHTTPMaxIdleConns(10), WithHTTPIdleConnTimeout(time.Minute))
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+
+	transport := w.(*HTTPWriter).HTTPClient.Transport.(*http.Transport)
+	if transport.MaxIdleConnsPerHost != 10 {
+		t.Errorf("MaxIdleConnsPerHost: expected 10, got %d", transport.MaxIdleConnsPerHost)
+	}
+	if transport.IdleConnTimeout != time.Minute {
+		t.Errorf("IdleConnTimeout: expected %s, got %s", time.Minute, transport.IdleConnTimeout)
+	}
+	if transport.MaxConnsPerHost != 0 {
+		t.Errorf("MaxConnsPerHost: expected no limit, got %d", transport.MaxConnsPerHost)
+	}
+
+	if _, err := NewWriter("http://127.0.0.1:12201/gelf", WithHTTPMaxIdleConns(-1)); err == nil {
+		t.Error("NewWriter should reject a negative number of idle connections")
+	}
+	if _, err := NewWriter("http://127.0.0.1:12201/gelf", WithHTTPMaxConnsPerHost(-1)); err == nil {
+		t.Error("NewWriter should reject a negative number of connections")
+	}
+}
+
+func TestHTTPWriterReusesConnections(t *testing.T) {
+	var mu sync.Mutex
+	opened := 0
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		ioutil.ReadAll(req.Body)
+		rw.WriteHeader(http.StatusAccepted)
+	}))
+	server.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mu.Lock()
+			opened++
+			mu.Unlock()
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	w, err := NewWriter(server.URL, WithHTTPMaxIdleConns(10), WithHTTPMaxConnsPerHost(10))
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	defer w.(*HTTPWriter).Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := w.WriteMessage(Logf(6, "burst")); err != nil {
+				t.Errorf("WriteMessage: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if opened > 10 {
+		t.Errorf("expected at most 10 connections for 100 messages, got %d", opened)
+	}
+}
+
+type headerTransport struct {
+	header, value string
+}
+
+func (t headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.Header.Set(t.header, t.value)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestNewHTTPWriter(t *testing.T) {
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		got = req.Header.Get("X-Tenant")
+		rw.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: headerTransport{"X-Tenant", "billing"}}
+	w, err := NewHTTPWriter(server.URL, client)
+	if err != nil {
+		t.Fatalf("NewHTTPWriter: %s", err)
+	}
+	if err := w.WriteMessage(Logf(6, "hello")); err != nil {
+		t.Fatalf("WriteMessage: %s", err)
+	}
+	if got != "billing" {
+		t.Errorf("expected the custom client to be used, got X-Tenant %q", got)
+	}
+	if err := w.(*HTTPWriter).Close(); err != nil {
+		t.Errorf("Close: %s", err)
+	}
+
+	w, err = NewHTTPWriter(server.URL, nil)
+	if err != nil {
+		t.Fatalf("NewHTTPWriter: %s", err)
+	}
+	if hw := w.(*HTTPWriter); hw.HTTPClient == nil || hw.HTTPClient.Timeout != 10*time.Second {
+		t.Errorf("expected the default client for a nil client, got %+v", hw.HTTPClient)
+	}
+	if err := w.WriteMessage(Logf(6, "hello")); err != nil {
+		t.Errorf("WriteMessage: %s", err)
+	}
+}
+
+func TestHTTPWriterGzip(t *testing.T) {
+	var encoding string
+	var m Message
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		encoding = req.Header.Get("Content-Encoding")
+		zr, err := gzip.NewReader(req.Body)
+		if err != nil {
+			t.Errorf("gzip.NewReader: %s", err)
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err := json.NewDecoder(zr).Decode(&m); err != nil {
+			t.Errorf("Decode: %s", err)
+		}
+		rw.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	w, err := NewWriter(server.URL)
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	hw := w.(*HTTPWriter)
+	if hw.CompressionType != NoCompress {
+		t.Errorf("expected NoCompress by default, got %d", hw.CompressionType)
+	}
+
+	hw.CompressionType = CompressGzip
+	if err := w.WriteMessage(Logf(6, "compressed")); err != nil {
+		t.Fatalf("WriteMessage: %s", err)
+	}
+	if encoding != "gzip" {
+		t.Errorf("expected Content-Encoding gzip, got %q", encoding)
+	}
+	if m.Short != "compressed" {
+		t.Errorf("expected short message %q, got %q", "compressed", m.Short)
+	}
+}
+
+func TestHTTPWriterRetries(t *testing.T) {
+	var mu sync.Mutex
+	var statuses []int
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		ioutil.ReadAll(req.Body)
+		status := http.StatusAccepted
+		if requests < len(statuses) {
+			status = statuses[requests]
+		}
+		requests++
+		if status == http.StatusTooManyRequests {
+			rw.Header().Set("Retry-After", "1")
+		}
+		rw.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	w, err := NewHTTPWriter(server.URL, nil)
+	if err != nil {
+		t.Fatalf("NewHTTPWriter: %s", err)
+	}
+	hw := w.(*HTTPWriter)
+	hw.RetryBackoff = time.Millisecond
+
+	reset := func(s ...int) {
+		mu.Lock()
+		defer mu.Unlock()
+		statuses, requests = s, 0
+	}
+
+	// not retried by default
+	reset(http.StatusServiceUnavailable)
+	if err := w.WriteMessage(Logf(6, "hello")); err == nil {
+		t.Error("expected an error without retries")
+	}
+
+	hw.MaxRetries = 2
+	reset(http.StatusBadGateway, http.StatusServiceUnavailable)
+	if err := w.WriteMessage(Logf(6, "hello")); err != nil {
+		t.Errorf("expected the retries to succeed, got %s", err)
+	}
+	if requests != 3 {
+		t.Errorf("expected 3 requests, got %d", requests)
+	}
+
+	reset(http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+	if err := w.WriteMessage(Logf(6, "hello")); err == nil || !strings.Contains(err.Error(), "expected 202") {
+		t.Errorf("expected the last error once retries are exhausted, got %v", err)
+	}
+
+	reset(http.StatusBadRequest)
+	if err := w.WriteMessage(Logf(6, "hello")); err == nil {
+		t.Error("expected an error for a 400 status")
+	}
+	if requests != 1 {
+		t.Errorf("expected a 400 status not to be retried, got %d requests", requests)
+	}
+
+	reset(http.StatusTooManyRequests)
+	start := time.Now()
+	if err := w.WriteMessage(Logf(6, "hello")); err != nil {
+		t.Errorf("expected the retry to succeed, got %s", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("expected Retry-After to be honoured, retried after %s", elapsed)
+	}
+}
+
+func TestHTTPWriterLiteral(t *testing.T) {
+	var encoding string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		encoding = req.Header.Get("Content-Encoding")
+		rw.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	// no client, and the zero CompressionType: gzip
+	w := &HTTPWriter{Addr: server.URL}
+	if err := w.WriteMessage(Logf(6, "hello")); err != nil {
+		t.Fatalf("WriteMessage: %s", err)
+	}
+	if encoding != "gzip" {
+		t.Errorf("expected a gzipped body, got encoding %q", encoding)
+	}
+	if err := w.Close(); err != nil {
+		t.Errorf("Close: %s", err)
+	}
+}
+
+func TestHTTPWriterRetryAfterLimit(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		requests++
+		rw.Header().Set("Retry-After", "86400")
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	w, err := NewHTTPWriter(server.URL, nil)
+	if err != nil {
+		t.Fatalf("NewHTTPWriter: %s", err)
+	}
+	hw := w.(*HTTPWriter)
+	hw.MaxRetries = 3
+
+	start := time.Now()
+	err = w.WriteMessage(Logf(6, "hello"))
+	if err == nil || !strings.Contains(err.Error(), "Retry-After 24h0m0s exceeds 10s") {
+		t.Errorf("expected the Retry-After delay to be rejected, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second || requests != 1 {
+		t.Errorf("expected a single request without waiting, got %d in %s", requests, elapsed)
+	}
+
+	hw.WriteTimeout = time.Minute
+	date := time.Now().Add(48 * time.Hour).UTC().Format(http.TimeFormat)
+	server.Config.Handler = http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Retry-After", date)
+		rw.WriteHeader(http.StatusTooManyRequests)
+	})
+	if err := w.WriteMessage(Logf(6, "hello")); err == nil || !strings.Contains(err.Error(), "exceeds 1m0s") {
+		t.Errorf("expected the Retry-After date to be rejected, got %v", err)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	if d := retryAfter("3"); d != 3*time.Second {
+		t.Errorf("expected 3s, got %s", d)
+	}
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if d := retryAfter(date); d <= 58*time.Second || d > time.Minute {
+		t.Errorf("expected about a minute, got %s", d)
+	}
+	for _, v := range []string{"", "soon", "-1"} {
+		if d := retryAfter(v); d != 0 {
+			t.Errorf("retryAfter(%q): expected 0, got %s", v, d)
+		}
+	}
+}
+
+func benchmarkHTTPWriter(b *testing.B, maxIdleConns int) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		ioutil.ReadAll(req.Body)
+		rw.WriteHeader(202)
+	}))
+	defer server.Close()
+
+	w, err := NewWriter(server.URL, WithHTTPMaxIdleConns(maxIdleConns))
+	if err != nil {
+		b.Fatalf("NewWriter: %s", err)
+	}
+	m := Logf(SyslogInfoLevel, "benchmark message")
+
+	b.SetParallelism(10)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := w.WriteMessage(m); err != nil {
+				b.Errorf("WriteMessage: %s", err)
+			}
+		}
+	})
+}
+
+func BenchmarkHTTPWriterMaxIdleConns1(b *testing.B)  { benchmarkHTTPWriter(b, 1) }
+func BenchmarkHTTPWriterMaxIdleConns10(b *testing.B) { benchmarkHTTPWriter(b, 10) }
+
+// lossyConn silently drops one write out of three
+type lossyConn struct {
+	net.Conn
+	writes    int
+	delivered [][]byte
+}
+
+func (c *lossyConn) Write(p []byte) (int, error) {
+	c.writes++
+	if c.writes%3 == 2 {
+		return len(p), nil
+	}
+	c.delivered = append(c.delivered, append([]byte(nil), p...))
+	return c.Conn.Write(p)
+}
+
+// deliveredChunks returns the distinct chunk sequence numbers delivered
+func (c *lossyConn) deliveredChunks() map[byte]bool {
+	seqs := map[byte]bool{}
+	for _, p := range c.delivered {
+		seqs[p[10]] = true
+	}
+	return seqs
+}
+
+func TestMaxRetransmissions(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+
+	m := Logf(SyslogInfoLevel, "chunked message")
+	m.Full = strings.Repeat("x", 3*chunkedDataLen+100) // 4 chunks
+
+	for _, retransmissions := range []int{0, 1} {
+		w, err := NewWriter(r.Addr())
+		if err != nil {
+			t.Fatalf("NewWriter: %s", err)
+		}
+		uw := w.(*UDPWriter)
+		uw.CompressionType = NoCompress
+		uw.MaxRetransmissions = retransmissions
+		conn := &lossyConn{Conn: uw.conn}
+		uw.conn = conn
+
+		if err := uw.WriteMessage(m); err != nil {
+			t.Fatalf("WriteMessage: %s", err)
+		}
+		if conn.writes != 4*(retransmissions+1) {
+			t.Errorf("%d retransmissions: expected %d writes, got %d", retransmissions, 4*(retransmissions+1), conn.writes)
+		}
+		complete := len(conn.deliveredChunks()) == 4
+		if complete != (retransmissions > 0) {
+			t.Errorf("%d retransmissions: delivered chunks %v", retransmissions, conn.deliveredChunks())
+		}
+	}
+}
+
+func TestLastSuccessfulWrite(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+	w, err := NewWriter(r.Addr())
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	uw := w.(*UDPWriter)
+
+	if !uw.LastSuccessfulWrite().IsZero() {
+		t.Errorf("LastSuccessfulWrite: expected zero time, got %s", uw.LastSuccessfulWrite())
+	}
+	if !uw.IsStale(time.Hour) {
+		t.Error("writer should be stale before the first write")
+	}
+
+	before := time.Now()
+	if _, err := uw.Write([]byte("alive")); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	if last := uw.LastSuccessfulWrite(); last.Before(before) || last.After(time.Now()) {
+		t.Errorf("LastSuccessfulWrite: expected a time after %s, got %s", before, last)
+	}
+	if uw.IsStale(time.Hour) {
+		t.Error("writer should not be stale right after a write")
+	}
+	time.Sleep(2 * time.Millisecond)
+	if !uw.IsStale(time.Millisecond) {
+		t.Error("writer should be stale after d without write")
+	}
+}
+
+func TestLastChunkID(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+	w, err := NewWriter(r.Addr())
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	uw := w.(*UDPWriter)
+	uw.CompressionType = NoCompress
+	conn := &lossyConn{Conn: uw.conn}
+	uw.conn = conn
+
+	m := Logf(SyslogInfoLevel, "chunked message")
+	m.Full = strings.Repeat("x", 2*chunkedDataLen)
+	if err := uw.WriteMessage(m); err != nil {
+		t.Fatalf("WriteMessage: %s", err)
+	}
+	var id [8]byte
+	copy(id[:], conn.delivered[0][2:10])
+	if got := uw.LastChunkID(); got != id {
+		t.Errorf("LastChunkID: expected %x, got %x", id, got)
+	}
+
+	if err := uw.WriteMessage(Logf(SyslogInfoLevel, "small message")); err != nil {
+		t.Fatalf("WriteMessage: %s", err)
+	}
+	if got := uw.LastChunkID(); got != [8]byte{} {
+		t.Errorf("LastChunkID: expected zeros after a single datagram, got %x", got)
+	}
+}
+
+func TestWithKeepAlive(t *testing.T) {
+	var mu sync.Mutex
+	pings := 0
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var m Message
+		if err := m.UnmarshalFromReader(req.Body); err == nil && m.Extra["_keepalive"] == true {
+			mu.Lock()
+			pings++
+			mu.Unlock()
+		}
+		rw.WriteHeader(202)
+	}))
+	defer server.Close()
+
+	w, err := NewWriter(server.URL, WithKeepAlive(5*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		mu.Lock()
+		n := pings
+		mu.Unlock()
+		if n >= 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected at least 2 pings, got %d", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	w.(*HTTPWriter).Close()
+	time.Sleep(10 * time.Millisecond)
+	mu.Lock()
+	n := pings
+	mu.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if pings != n {
+		t.Errorf("pings should stop once the writer is closed")
+	}
+}
+
+// logLines sends each line written by a log.Logger on a channel
+type logLines chan string
+
+func (l logLines) Write(p []byte) (int, error) {
+	select {
+	case l <- string(p):
+	default:
+	}
+	return len(p), nil
+}
+
+func TestWithErrorLogger(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(500)
+	}))
+	defer server.Close()
+
+	lines := make(logLines, 16)
+	w, err := NewWriter(server.URL, WithKeepAlive(5*time.Millisecond), WithErrorLogger(log.New(lines, "", 0)))
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	defer w.(*HTTPWriter).Close()
+
+	select {
+	case line := <-lines:
+		if !strings.HasPrefix(line, "graylog: keep-alive ping failed: ") {
+			t.Errorf("unexpected log line %q", line)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected the failed ping to be logged")
+	}
+}
+
+func TestWithExtraByteSliceEncoding(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+
+	payload := []byte{0xde, 0xad, 0xbe, 0xef}
+	tests := map[ByteSliceEncoding]interface{}{
+		ByteSliceBase64: "3q2+7w==",
+		ByteSliceHex:    "deadbeef",
+		ByteSliceLength: json.Number("4"),
+	}
+	for enc, expected := range tests {
+		w, err := NewWriter(r.Addr(), WithExtraByteSliceEncoding(enc))
+		if err != nil {
+			t.Fatalf("NewWriter: %s", err)
+		}
+
+		m := Logf(SyslogInfoLevel, "binary").AppendExtra("hash", payload)
+		if err := w.WriteMessage(m); err != nil {
+			t.Fatalf("WriteMessage: %s", err)
+		}
+		if !bytes.Equal(m.Extra["_hash"].([]byte), payload) {
+			t.Errorf("WriteMessage should not modify the message, got %v", m.Extra["_hash"])
+		}
+
+		msg, err := r.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage: %s", err)
+		}
+		if msg.Extra["_hash"] != expected {
+			t.Errorf("encoding %d: expected %#v, got %#v", enc, expected, msg.Extra["_hash"])
+		}
+	}
+
+	if _, err := NewWriter(r.Addr(), WithExtraByteSliceEncoding(42)); err == nil {
+		t.Error("NewWriter should reject an unknown encoding")
+	}
+}
+
+func TestWithHTTPProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// requests to a proxy carry the absolute target URL
+		proxied = req.URL.String()
+		rw.WriteHeader(202)
+	}))
+	defer proxy.Close()
+
+	w, err := NewWriter("http://graylog.invalid:12201/gelf", WithHTTPProxy(proxy.URL))
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	if err := w.WriteMessage(Logf(SyslogInfoLevel, "proxied")); err != nil {
+		t.Fatalf("WriteMessage: %s", err)
+	}
+	if proxied != "http://graylog.invalid:12201/gelf" {
+		t.Errorf("proxy: expected a request for http://graylog.invalid:12201/gelf, got %q", proxied)
+	}
+
+	for _, invalid := range []string{"::", "proxy.corp:3128", "http://"} {
+		if _, err := NewWriter("http://graylog.invalid:12201/gelf", WithHTTPProxy(invalid)); err == nil {
+			t.Errorf("NewWriter should reject proxy URL %q", invalid)
+		}
+	}
+
+	if _, err := NewWriter("http://graylog.invalid:12201/gelf", WithSystemProxy()); err != nil {
+		t.Errorf("NewWriter: %s", err)
+	}
+}
+
+func TestNullGzipCompressor(t *testing.T) {
+	data := []byte(strings.Repeat("uncompressed ", 100))
+
+	var buf bytes.Buffer
+	zw := NewNullGzipCompressor(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	zw.Close()
+
+	// 10 bytes header, 8 bytes trailer and the stored blocks headers
+	if overhead := buf.Len() - len(data); overhead < 18 || overhead > 30 {
+		t.Errorf("expected a small framing overhead, got %d bytes", overhead)
+	}
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %s", err)
+	}
+	got, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("ReadAll: %s", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("expected the original data back")
+	}
+}
+
+func TestBestFor(t *testing.T) {
+	for size, expected := range map[int]CompressType{
+		0: NoCompress, 511: NoCompress, 512: CompressGzip, 100 * 1024: CompressGzip, 1 << 20: CompressZstd,
+	} {
+		if ct := CompressZlib.BestFor(size); ct != expected {
+			t.Errorf("BestFor(%d): expected %d, got %d", size, expected, ct)
+		}
+	}
+}
+
+func TestBenchmarkCompression(t *testing.T) {
+	sample, err := json.Marshal(Message{
+		Version: GELFVersion,
+		Host:    "api-1",
+		Short:   strings.Repeat("GET /v1/projects 200 ", 50),
+		Extra:   map[string]interface{}{"_request_id": "8a1f6c2e"},
+	})
+	if err != nil {
+		t.Fatalf("Marshal: %s", err)
+	}
+	if ct := BenchmarkCompression(sample); ct == NoCompress {
+		t.Errorf("expected a codec to be picked for a compressible sample")
+	}
+
+	random := make([]byte, 1024)
+	rand.New(rand.NewSource(1)).Read(random)
+	if ct := BenchmarkCompression(random); ct != NoCompress {
+		t.Errorf("expected NoCompress for random data, got %d", ct)
+	}
+	if ct := BenchmarkCompression(nil); ct != NoCompress {
+		t.Errorf("expected NoCompress for an empty sample, got %d", ct)
+	}
+}
+
+func TestWithAdaptiveCompression(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket: %s", err)
+	}
+	defer conn.Close()
+
+	w, err := NewWriter(conn.LocalAddr().String(), WithAdaptiveCompression())
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+
+	readPacket := func() []byte {
+		buf := make([]byte, 8192)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("ReadFrom: %s", err)
+		}
+		return buf[:n]
+	}
+
+	small := Logf(SyslogInfoLevel, "small")
+	large := Logf(SyslogInfoLevel, "large")
+	large.Full = strings.Repeat("a fairly repetitive stack trace line\n", 30)
+
+	for _, m := range []*Message{small, large, small} {
+		if err := w.WriteMessage(m); err != nil {
+			t.Fatalf("WriteMessage: %s", err)
+		}
+		p := readPacket()
+		if m == small && p[0] != '{' {
+			t.Errorf("small message: expected uncompressed JSON, got %x", p[:2])
+		}
+		if m == large && !bytes.HasPrefix(p, magicGzip) {
+			t.Errorf("large message: expected gzip, got %x", p[:2])
+		}
+	}
+}
+
+// benchmarkMessageSizes writes messages of a typical size distribution:
+// mostly short lines, some stack traces and a few large payloads.
+func benchmarkMessageSizes(b *testing.B, opts ...WriterOption) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		b.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+	w, err := NewWriter(r.Addr(), opts...)
+	if err != nil {
+		b.Fatalf("NewWriter: %s", err)
+	}
+
+	var messages []*Message
+	for i, lines := range []int{0, 0, 0, 0, 0, 0, 0, 2, 30, 300} {
+		m := Logf(SyslogInfoLevel, "benchmark message %d", i)
+		m.Full = strings.Repeat("a fairly repetitive stack trace line\n", lines)
+		messages = append(messages, m)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := w.WriteMessage(messages[i%len(messages)]); err != nil {
+			b.Fatalf("WriteMessage: %s", err)
+		}
+	}
+}
+
+func BenchmarkFixedCompression(b *testing.B)    { benchmarkMessageSizes(b) }
+func BenchmarkAdaptiveCompression(b *testing.B) { benchmarkMessageSizes(b, WithAdaptiveCompression()) }
+
+func TestUDPWriterCopy(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+	w, err := NewWriter(r.Addr())
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	orig := w.(*UDPWriter)
+	orig.SetCompression(CompressZlib, flate.BestCompression)
+	orig.Facility = "billing"
+	if err := orig.SetChunkSize(1500); err != nil {
+		t.Fatalf("SetChunkSize: %s", err)
+	}
+
+	c, err := orig.Copy()
+	if err != nil {
+		t.Fatalf("Copy: %s", err)
+	}
+	if c.conn == orig.conn || c.conn.LocalAddr().String() == orig.conn.LocalAddr().String() {
+		t.Error("Copy should open its own socket")
+	}
+	if c.CompressionType != CompressZlib || c.CompressionLevel != flate.BestCompression || c.Facility != "billing" {
+		t.Errorf("Copy: expected the same settings, got %d/%d/%s", c.CompressionType, c.CompressionLevel, c.Facility)
+	}
+	if c.chunkSize != orig.chunkSize {
+		t.Errorf("Copy: expected chunk size %d, got %d", orig.chunkSize, c.chunkSize)
+	}
+
+	// closing the original doesn't affect the copy
+	orig.conn.Close()
+	if _, err := c.Write([]byte("from the copy")); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	msg, err := r.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+	if msg.Short != "from the copy" || msg.Facility != "billing" {
+		t.Errorf("message: expected a billing message from the copy, got %s/%q", msg.Facility, msg.Short)
+	}
+}
+
+func TestSetRemoteAddr(t *testing.T) {
+	before, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer before.conn.Close()
+	after, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer after.conn.Close()
+
+	w, err := NewWriter(before.Addr())
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	uw := w.(*UDPWriter)
+
+	uw.Write([]byte("before the swap"))
+	if err := uw.SetRemoteAddr(after.Addr()); err != nil {
+		t.Fatalf("SetRemoteAddr: %s", err)
+	}
+	uw.Write([]byte("after the swap"))
+
+	for r, expected := range map[*Reader]string{before: "before the swap", after: "after the swap"} {
+		msg, err := r.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage: %s", err)
+		}
+		if msg.Short != expected {
+			t.Errorf("%s: expected %q, got %q", r.Addr(), expected, msg.Short)
+		}
+	}
+
+	if err := uw.SetRemoteAddr("127.0.0.1:notaport"); err == nil {
+		t.Error("SetRemoteAddr should reject an invalid address")
+	}
+}
+
+func TestWriteMessageIf(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+	w, err := NewWriter(r.Addr())
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	uw := w.(*UDPWriter)
+
+	if err := WriteMessageIf(uw, Logf(SyslogDebug, "skipped"), false); err != nil {
+		t.Fatalf("WriteMessageIf: %s", err)
+	}
+	if err := WriteMessageIf(uw, Logf(SyslogDebug, "sent"), true); err != nil {
+		t.Fatalf("WriteMessageIf: %s", err)
+	}
+	if msg, err := r.ReadMessage(); err != nil || msg.Short != "sent" {
+		t.Errorf("ReadMessage: expected the sent message only, got %v (%v)", msg, err)
+	}
+
+	dest := &flakyWriter{}
+	mw := NewMiddlewareWriter(dest)
+	WriteMessageIf(mw, Logf(SyslogDebug, "skipped"), false)
+	WriteMessageIf(mw, Logf(SyslogDebug, "sent"), true)
+	if got := dest.messages(); len(got) != 1 || got[0] != "sent" {
+		t.Errorf("messages: expected [sent], got %v", got)
+	}
+}
+
+func TestUDPWriterReconnect(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+	w, err := NewWriter(r.Addr())
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	uw := w.(*UDPWriter)
+
+	// the socket is closed under the writer's feet
+	uw.conn.Close()
+	if _, err := uw.Write([]byte("lost")); err == nil {
+		t.Fatal("Write should fail without reconnection attempts")
+	}
+
+	uw.MaxReconnectAttempts = 2
+	uw.ReconnectDelay = time.Millisecond
+	if _, err := uw.Write([]byte("after reconnection")); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	msg, err := r.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+	if msg.Short != "after reconnection" {
+		t.Errorf("expected the message sent after reconnection, got %q", msg.Short)
+	}
+}
+
+// timeoutError is a net.Error for write deadlines exceeded
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// timeoutConn times out all the writes
+type timeoutConn struct {
+	net.Conn
+}
+
+func (c timeoutConn) Write(p []byte) (int, error) {
+	return 0, &net.OpError{Op: "write", Net: "udp", Err: timeoutError{}}
+}
+
+func TestUDPWriterTimeoutKeepsConn(t *testing.T) {
+	w, err := NewWriter("127.0.0.1:12201")
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	uw := w.(*UDPWriter)
+	uw.MaxReconnectAttempts = 2
+	uw.ReconnectDelay = time.Millisecond
+	conn := timeoutConn{uw.conn}
+	uw.conn = conn
+
+	_, err = uw.Write([]byte("slow"))
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("expected a timeout error, got %v", err)
+	}
+	if uw.conn != conn {
+		t.Error("a timeout should not replace the connection")
+	}
+}
+
+// deadlineConn records the write deadlines set on the connection
+type deadlineConn struct {
+	net.Conn
+	deadlines []time.Time
+}
+
+func (c *deadlineConn) SetWriteDeadline(t time.Time) error {
+	c.deadlines = append(c.deadlines, t)
+	return c.Conn.SetWriteDeadline(t)
+}
+
+func TestWithWriteTimeout(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+	w, err := NewWriter(r.Addr(), WithWriteTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	uw := w.(*UDPWriter)
+	conn := &deadlineConn{Conn: uw.conn}
+	uw.conn = conn
+
+	if _, err := uw.Write([]byte("with a deadline")); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	if len(conn.deadlines) != 2 || conn.deadlines[0].IsZero() || !conn.deadlines[1].IsZero() {
+		t.Errorf("expected a deadline to be set and reset, got %v", conn.deadlines)
+	}
+
+	slow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		rw.WriteHeader(202)
+	}))
+	defer slow.Close()
+
+	hw, err := NewWriter(slow.URL, WithWriteTimeout(20*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	if err := hw.WriteMessage(Logf(SyslogInformational, "too slow")); err == nil {
+		t.Error("WriteMessage should time out")
+	}
+	if hw, _ = NewWriter(slow.URL); hw.WriteMessage(Logf(SyslogInformational, "slow")) != nil {
+		t.Error("WriteMessage should not time out without WriteTimeout")
+	}
+
+	if _, err := NewWriter(r.Addr(), WithWriteTimeout(-time.Second)); err == nil {
+		t.Error("NewWriter should reject negative timeouts")
+	}
+}
+
+func TestUDPWriterClose(t *testing.T) {
+	w, err := NewWriter("127.0.0.1:12201")
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	c, ok := w.(GELFCloser)
+	if !ok {
+		t.Fatalf("%T should implement GELFCloser", w)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("Close should be idempotent, got %s", err)
+	}
+	if err := w.WriteMessage(Logf(SyslogInformational, "closed")); err != ErrWriterClosed {
+		t.Errorf("WriteMessage: expected %v, got %v", ErrWriterClosed, err)
+	}
+	if _, err := w.(*UDPWriter).Copy(); err != ErrWriterClosed {
+		t.Errorf("Copy: expected %v, got %v", ErrWriterClosed, err)
+	}
+}
+
+var (
+	_ GELFCloser = (*UDPWriter)(nil)
+	_ GELFCloser = (*TCPWriter)(nil)
+	_ GELFCloser = &HTTPWriter{}
+	_ GELFCloser = (*AsyncWriter)(nil)
+	_ GELFCloser = (*WriterGroup)(nil)
+)
+
+// countingConn counts the datagrams written
+type countingConn struct {
+	net.Conn
+	writes int
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	c.writes++
+	return c.Conn.Write(p)
+}
+
+func TestSetChunkSize(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+	w, err := NewWriter(r.Addr())
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	uw := w.(*UDPWriter)
+	uw.CompressionLevel = gzip.NoCompression
+	conn := &countingConn{Conn: uw.conn}
+	uw.conn = conn
+
+	// jumbo frames: 8972 bytes chunks
+	if err := uw.SetChunkSize(9000); err != nil {
+		t.Fatalf("SetChunkSize: %s", err)
+	}
+	if uw.chunkSize != 8972 {
+		t.Errorf("SetChunkSize: expected 8972 bytes chunks, got %d", uw.chunkSize)
+	}
+	m := Logf(SyslogInformational, "jumbo")
+	m.Full = strings.Repeat("x", 20000)
+	if err := uw.WriteMessage(m); err != nil {
+		t.Fatalf("WriteMessage: %s", err)
+	}
+	if conn.writes != 3 {
+		t.Errorf("expected 3 chunks, got %d", conn.writes)
+	}
+	msg, err := r.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+	if msg.Full != m.Full {
+		t.Errorf("expected the whole message, got %d bytes", len(msg.Full))
+	}
+
+	for _, mtu := range []int{127, 65536} {
+		if err := uw.SetChunkSize(mtu); err == nil {
+			t.Errorf("SetChunkSize(%d) should fail", mtu)
+		}
+	}
+
+	// the IPv6 header is 20 bytes larger
+	if w, err := NewWriter("[::1]:12201"); err != nil {
+		t.Logf("no IPv6 support: %s", err)
+	} else if err := w.(*UDPWriter).SetChunkSize(9000); err != nil || w.(*UDPWriter).chunkSize != 8952 {
+		t.Errorf("SetChunkSize over IPv6: expected 8952 bytes chunks, got %d (%v)", w.(*UDPWriter).chunkSize, err)
+	}
+}
+
+// failingConn fails all the writes
+type failingConn struct {
+	net.Conn
+}
+
+func (c failingConn) Write(p []byte) (int, error) {
+	return 0, errors.New("connection refused")
+}
+
+func TestChunkError(t *testing.T) {
+	w, err := NewWriter("127.0.0.1:12201")
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	uw := w.(*UDPWriter)
+	uw.CompressionType = NoCompress
+	if err := uw.SetChunkSize(128); err != nil {
+		t.Fatalf("SetChunkSize: %s", err)
+	}
+
+	m := Logf(SyslogInformational, "too large")
+	m.Full = strings.Repeat("x", 255*100)
+	err = uw.WriteMessage(m)
+	var chunkErr *ChunkError
+	if !errors.As(err, &chunkErr) || chunkErr.Kind != TooManyChunks {
+		t.Fatalf("expected a TooManyChunks error, got %v", err)
+	}
+	if chunkErr.TotalChunks <= 255 || !strings.Contains(err.Error(), "msg too large") {
+		t.Errorf("unexpected error: %+v", chunkErr)
+	}
+
+	uw.conn = failingConn{uw.conn}
+	m.Full = strings.Repeat("x", 500)
+	err = uw.WriteMessage(m)
+	if !errors.As(err, &chunkErr) || chunkErr.Kind != ChunkWriteFailed || chunkErr.ChunkIndex != 0 {
+		t.Fatalf("expected a ChunkWriteFailed error for the first chunk, got %v", err)
+	}
+	if chunkErr.TotalChunks < 2 || errors.Unwrap(chunkErr) == nil {
+		t.Errorf("unexpected error: %+v", chunkErr)
+	}
+}
+
+func TestMaxMessageBytes(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+	w, err := NewWriter(r.Addr())
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	uw := w.(*UDPWriter)
+	uw.MaxMessageBytes = 1024
+
+	m := Logf(SyslogError, "panic").AppendExtra("request", strings.Repeat("r", 100))
+	m.Full = strings.Repeat("goroutine 1 [running]:\n", 100)
+	err = uw.WriteMessage(m)
+	tooLarge, ok := err.(*MessageTooLargeError)
+	if !ok {
+		t.Fatalf("expected a *MessageTooLargeError, got %v", err)
+	}
+	if tooLarge.Field != "full_message" || tooLarge.FieldSize != len(m.Full) || tooLarge.Limit != 1024 || tooLarge.Size <= 1024 {
+		t.Errorf("unexpected error: %+v", tooLarge)
+	}
+	if !strings.Contains(err.Error(), "full_message") {
+		t.Errorf("expected the field name in %q", err)
+	}
+
+	m.Full = ""
+	if err := uw.WriteMessage(m); err != nil {
+		t.Fatalf("WriteMessage: %s", err)
+	}
+	if msg, err := r.ReadMessage(); err != nil || msg.Short != "panic" {
+		t.Errorf("ReadMessage: expected the message under the limit, got %v (%v)", msg, err)
+	}
+}
+
+func TestFacilityFunc(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+	w, err := NewWriter(r.Addr())
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	uw := w.(*UDPWriter)
+	tenant := "acme"
+	uw.FacilityFunc = func() string { return "tenant-" + tenant }
+
+	if _, err := uw.Write([]byte("written")); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	tenant = "globex"
+	m := Logf(SyslogInformational, "no facility")
+	if err := uw.WriteMessage(m); err != nil {
+		t.Fatalf("WriteMessage: %s", err)
+	}
+	if m.Facility != "" {
+		t.Error("WriteMessage should not modify the message")
+	}
+	explicit := Logf(SyslogInformational, "explicit facility")
+	explicit.Facility = "billing"
+	if err := uw.WriteMessage(explicit); err != nil {
+		t.Fatalf("WriteMessage: %s", err)
+	}
+
+	for _, expected := range []string{"tenant-acme", "tenant-globex", "billing"} {
+		msg, err := r.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage: %s", err)
+		}
+		if msg.Facility != expected {
+			t.Errorf("%q: expected facility %s, got %s", msg.Short, expected, msg.Facility)
+		}
+	}
+}
+
+func TestNewUDPWriter(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+
+	w, err := NewUDPWriter(r.Addr(),
+		WithCompressionType(CompressZlib),
+		WithCompressionLevel(flate.BestCompression),
+		WithFacility("billing"),
+		WithHostname("billing-1"),
+		WithWriteTimeout(time.Second),
+		WithChunkSize(8000))
+	if err != nil {
+		t.Fatalf("NewUDPWriter: %s", err)
+	}
+	uw := w.(*UDPWriter)
+	if uw.CompressionType != CompressZlib || uw.CompressionLevel != flate.BestCompression {
+		t.Errorf("compression: got %d at level %d", uw.CompressionType, uw.CompressionLevel)
+	}
+	if uw.Facility != "billing" || uw.hostname != "billing-1" {
+		t.Errorf("expected facility billing and hostname billing-1, got %q and %q", uw.Facility, uw.hostname)
+	}
+	if uw.WriteTimeout != time.Second || uw.chunkSize != 8000 {
+		t.Errorf("expected a 1s timeout and 8000 bytes chunks, got %s and %d", uw.WriteTimeout, uw.chunkSize)
+	}
+
+	if _, err := uw.Write([]byte("configured")); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	msg, err := r.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+	if msg.Facility != "billing" || msg.Host != "billing-1" {
+		t.Errorf("expected facility billing and host billing-1, got %q and %q", msg.Facility, msg.Host)
+	}
+
+	w, err = NewUDPWriter(r.Addr(), WithCompressionLevel(flate.NoCompression))
+	if err != nil {
+		t.Fatalf("NewUDPWriter: %s", err)
+	}
+	if uw := w.(*UDPWriter); uw.CompressionType != CompressGzip || uw.CompressionLevel != flate.NoCompression || uw.chunkSize != ChunkSize {
+		t.Errorf("defaults: got %d at level %d, %d bytes chunks", uw.CompressionType, uw.CompressionLevel, uw.chunkSize)
+	}
+
+	for _, addr := range []string{"tcp://127.0.0.1:12201", "http://127.0.0.1:12201/gelf"} {
+		_, err := NewWriter(addr, WithCompressionLevel(flate.BestCompression), WithChunkSize(8000))
+		if err == nil || !strings.Contains(err.Error(), "WithCompressionLevel, WithChunkSize") {
+			t.Errorf("%s: expected the UDP options to be rejected, got %v", addr, err)
+		}
+	}
+
+	invalid := map[string]UDPOption{
+		"compression type": WithCompressionType(CompressType(42)),
+		"facility":         WithFacility(""),
+		"chunk size":       WithChunkSize(99),
+	}
+	for name, opt := range invalid {
+		if _, err := NewUDPWriter(r.Addr(), opt); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestDialContext(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %s", err)
+	}
+	defer l.Close()
+	addrs := []string{r.Addr(), "tcp://" + l.Addr().String()}
+
+	for _, addr := range addrs {
+		w, err := DialContext(context.Background(), addr, WithExtraByteSliceEncoding(ByteSliceLength))
+		if err != nil {
+			t.Fatalf("DialContext(%s): %s", addr, err)
+		}
+		w.(GELFCloser).Close()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, addr := range addrs {
+		if _, err := DialContext(ctx, addr); !errors.Is(err, context.Canceled) {
+			t.Errorf("DialContext(%s): expected context.Canceled, got %v", addr, err)
+		}
+	}
+}
@@ -1,8 +1,15 @@
 module github.com/gemnasium/logrus-graylog-hook/v3
 
 require (
+	github.com/golang/snappy v0.0.4
+	github.com/hashicorp/consul/api v1.10.0
+	github.com/klauspost/compress v1.11.13
 	github.com/pkg/errors v0.8.1
-	github.com/sirupsen/logrus v1.3.0
+	github.com/sirupsen/logrus v1.5.0
+	github.com/stretchr/objx v0.1.1 // indirect
+	go.opentelemetry.io/otel/trace v1.0.0
+	go.uber.org/zap v1.21.0
+	golang.org/x/time v0.3.0
 )
 
 go 1.13
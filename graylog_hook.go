@@ -2,11 +2,19 @@ package graylog
 
 import (
 	"bytes"
+	"context"
+	"encoding"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"os"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -21,22 +29,84 @@ var BufSize uint = 8192
 
 // GraylogHook to send logs to a logging service compatible with the Graylog API and the GELF format.
 type GraylogHook struct {
-	Extra       map[string]interface{}
-	Host        string
-	Level       logrus.Level
+	dropped uint64 // first for the alignment of atomic operations on 32-bit platforms
+
+	Extra            map[string]interface{}
+	Host             string
+	Level            logrus.Level
+	SanitizeHostname bool // make Host RFC 1123 compliant in the messages sent
+	// LevelMapper returns the syslog severity sent for a logrus level,
+	// SyslogLevel is used when nil.
+	LevelMapper func(logrus.Level) int32
+	// CallerDepth is the number of stack frames searched for the caller
+	// file and line when the logger doesn't report them, see
+	// logrus.Logger.ReportCaller. The search is disabled when 0.
+	CallerDepth int
+	// FieldPrefix is prepended to the logrus field names, after the "_" of
+	// GELF additional fields, to avoid collisions with reserved names like
+	// "level" or "timestamp". Without prefix, the default, a warning is
+	// logged the first time a field collides with a reserved name.
+	FieldPrefix string
+	// BlacklistedFields and BlacklistPatterns name the fields never sent,
+	// like passwords or tokens, whether they come from the entry or the
+	// hook Extra. Names are matched exactly but case-insensitively, and
+	// patterns anywhere in the field name unless anchored, like
+	// `_password$`.
+	BlacklistedFields []string
+	BlacklistPatterns []*regexp.Regexp
+	// TruncateOnOverflow makes the hook truncate the full message, then
+	// the short one, of the entries the writer rejects as too large, see
+	// UDPWriter.TruncateOnOverflow, instead of losing them.
+	TruncateOnOverflow bool
+	// FacilityFunc, when set, is called for each entry to set the facility
+	// of its message. Otherwise the writer sets it, see
+	// UDPWriter.FacilityFunc.
+	FacilityFunc func() string
+	// ContextExtractor, when set, is called with the context of the
+	// entries logged with logrus.WithContext, to add request-scoped fields
+	// stored in it once by a middleware, like a trace or user ID. They
+	// override the hook Extra, and are overridden by the entry fields.
+	ContextExtractor func(ctx context.Context) map[string]interface{}
+	// DeduplicationWindow, when set, makes the hook send a message only once
+	// per window, for each level and short message. The repetitions are
+	// counted instead: at the end of the window, the last one is sent with
+	// their count in the SuppressedCountKey field. DeduplicationCacheSize messages
+	// are tracked, DefaultDeduplicationCacheSize when 0. Both are read
+	// when the first entry is sent.
+	DeduplicationWindow    time.Duration
+	DeduplicationCacheSize int
+
 	gelfLogger  GELFWriter
 	buf         chan graylogEntry
 	wg          sync.WaitGroup
 	mu          sync.RWMutex
 	synchronous bool
 	blacklist   map[string]bool
+	localFields func(*logrus.Entry) map[string]interface{}
+	rateLimiter RateLimiter
+	dedup       *dedupCache
+	dedupOnce   sync.Once
+	levelRates  LevelRateLimiter
+	warned      sync.Map // reserved field names already warned about
+	strict      bool     // StrictMode when the hook was created
+}
+
+// reservedFields are the names of the GELF message fields, and of the
+// fields set by Graylog, that an additional field of the same name
+// collides with once Graylog strips its "_".
+var reservedFields = map[string]bool{
+	"id": true, "version": true, "host": true, "short_message": true,
+	"full_message": true, "timestamp": true, "level": true, "facility": true,
+	"file": true, "line": true, "message": true, "source": true,
 }
 
 // Graylog needs file and line params
 type graylogEntry struct {
 	*logrus.Entry
-	file string
-	line int
+	file          string
+	line          int
+	localFields   map[string]interface{}
+	contextFields map[string]interface{}
 }
 
 // NewGraylogHook creates a hook to be added to an instance of logger.
@@ -57,6 +127,7 @@ func NewGraylogHook(addr string, extra map[string]interface{}) *GraylogHook {
 		Level:       logrus.DebugLevel,
 		gelfLogger:  g,
 		synchronous: true,
+		strict:      StrictMode,
 	}
 
 	return hook
@@ -82,6 +153,7 @@ func NewAsyncGraylogHook(addr string, extra map[string]interface{}) *GraylogHook
 		Level:      logrus.DebugLevel,
 		gelfLogger: g,
 		buf:        make(chan graylogEntry, BufSize),
+		strict:     StrictMode,
 	}
 	go hook.fire() // Log in background
 
@@ -95,12 +167,30 @@ func (hook *GraylogHook) Fire(entry *logrus.Entry) error {
 	hook.mu.RLock() // Claim the mutex as a RLock - allowing multiple go routines to log simultaneously
 	defer hook.mu.RUnlock()
 
+	// logrus registers the hook levels once, when it is added, so filter
+	// out the entries below a Level changed afterwards
+	if entry.Level > hook.Level {
+		return nil
+	}
+	// check the level limit first, so that the dropped Debug entries don't
+	// use up the tokens of the others
+	if hook.levelRates != nil && !hook.levelRates.AllowLevel(entry.Level) {
+		atomic.AddUint64(&hook.dropped, 1)
+		return nil
+	}
+	if hook.rateLimiter != nil && !hook.rateLimiter.Allow() {
+		atomic.AddUint64(&hook.dropped, 1)
+		return nil
+	}
+
 	var file string
 	var line int
 
 	if entry.Caller != nil {
 		file = entry.Caller.File
 		line = entry.Caller.Line
+	} else if hook.CallerDepth > 0 {
+		file, line = findCaller(hook.CallerDepth)
 	}
 
 	newData := make(map[string]interface{})
@@ -115,8 +205,15 @@ func (hook *GraylogHook) Fire(entry *logrus.Entry) error {
 		Level:   entry.Level,
 		Caller:  entry.Caller,
 		Message: entry.Message,
+		Context: entry.Context,
+	}
+	gEntry := graylogEntry{newEntry, file, line, nil, nil}
+	if hook.localFields != nil {
+		gEntry.localFields = hook.localFields(entry)
+	}
+	if hook.ContextExtractor != nil && entry.Context != nil {
+		gEntry.contextFields = hook.ContextExtractor(entry.Context)
 	}
-	gEntry := graylogEntry{newEntry, file, line}
 
 	if hook.synchronous {
 		hook.sendEntry(gEntry)
@@ -130,11 +227,80 @@ func (hook *GraylogHook) Fire(entry *logrus.Entry) error {
 
 // Flush waits for the log queue to be empty.
 // This func is meant to be used when the hook was created with NewAsyncGraylogHook.
+// The counts of the repetitions suppressed by the DeduplicationWindow are
+// sent too.
 func (hook *GraylogHook) Flush() {
 	hook.mu.Lock() // claim the mutex as a Lock - we want exclusive access to it
 	defer hook.mu.Unlock()
 
 	hook.wg.Wait()
+	if hook.dedup != nil {
+		hook.dedup.flushAll()
+	}
+}
+
+// WaitForPendingMessages waits for the log queue, and the AsyncWriter queue
+// if the hook writer is one, to be empty, but gives up after timeout and
+// returns context.DeadlineExceeded.
+// It returns immediately for synchronous hooks with a synchronous writer.
+func (hook *GraylogHook) WaitForPendingMessages(timeout time.Duration) error {
+	aw, async := hook.gelfLogger.(*AsyncWriter)
+	if hook.synchronous && !async {
+		return nil
+	}
+
+	// the lock is taken under the timeout too, as a Fire blocked on the
+	// writer holds it. On timeout, it is released once the wait is over.
+	done := make(chan struct{})
+	go func() {
+		hook.mu.Lock()
+		defer hook.mu.Unlock()
+
+		hook.wg.Wait()
+		if async {
+			aw.Flush()
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		return context.DeadlineExceeded
+	}
+}
+
+// findCallerSkip is the number of frames skipped by findCaller to start
+// from the caller of Fire: runtime.Callers, findCaller and Fire.
+const findCallerSkip = 3
+
+// findCaller returns the file and line of the first of the depth frames
+// above Fire outside of the logrus package. It must only be called by Fire.
+func findCaller(depth int) (string, int) {
+	pcs := make([]uintptr, depth)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(findCallerSkip, pcs)])
+	for {
+		frame, more := frames.Next()
+		if packageName(frame.Function) != "github.com/sirupsen/logrus" {
+			return frame.File, frame.Line
+		}
+		if !more {
+			return "", 0
+		}
+	}
+}
+
+// packageName returns the package of a fully qualified function name.
+func packageName(f string) string {
+	for {
+		lastPeriod := strings.LastIndex(f, ".")
+		lastSlash := strings.LastIndex(f, "/")
+		if lastPeriod <= lastSlash {
+			return f
+		}
+		f = f[:lastPeriod]
+	}
 }
 
 // fire will loop on the 'buf' channel, and write entries to graylog
@@ -146,33 +312,51 @@ func (hook *GraylogHook) fire() {
 	}
 }
 
-func logrusLevelToSyslog(level logrus.Level) int32 {
-	const (
-		LOG_EMERG   = 0 /* system is unusable */
-		LOG_ALERT   = 1 /* action must be taken immediately */
-		LOG_CRIT    = 2 /* critical conditions */
-		LOG_ERR     = 3 /* error conditions */
-		LOG_WARNING = 4 /* warning conditions */
-		LOG_NOTICE  = 5 /* normal but significant condition */
-		LOG_INFO    = 6 /* informational */
-		LOG_DEBUG   = 7 /* debug-level messages */
-	)
-	// logrus has no equivalent of syslog LOG_NOTICE
+// SyslogLevel returns the syslog severity of a logrus level, the mapping
+// used by default by the hook:
+//
+//	PanicLevel  SyslogAlert (1)
+//	FatalLevel  SyslogCritical (2)
+//	ErrorLevel  SyslogError (3)
+//	WarnLevel   SyslogWarning (4)
+//	InfoLevel   SyslogInformational (6)
+//	DebugLevel  SyslogDebug (7)
+//	TraceLevel  SyslogDebug (7)
+//
+// logrus has no equivalent of SyslogEmergency and SyslogNotice.
+func SyslogLevel(level logrus.Level) int32 {
 	switch level {
 	case logrus.PanicLevel:
-		return LOG_ALERT
+		return SyslogAlert
 	case logrus.FatalLevel:
-		return LOG_CRIT
+		return SyslogCritical
 	case logrus.ErrorLevel:
-		return LOG_ERR
+		return SyslogError
 	case logrus.WarnLevel:
-		return LOG_WARNING
+		return SyslogWarning
 	case logrus.InfoLevel:
-		return LOG_INFO
-	case logrus.DebugLevel, logrus.TraceLevel:
-		return LOG_DEBUG
+		return SyslogInformational
+	default:
+		return SyslogDebug
+	}
+}
+
+// syslogLevelToLogrus is the reverse of SyslogLevel. Levels without
+// an exact equivalent map to the closest logrus level.
+func syslogLevelToLogrus(level int32) logrus.Level {
+	switch {
+	case level <= SyslogAlert:
+		return logrus.PanicLevel
+	case level == SyslogCritical:
+		return logrus.FatalLevel
+	case level == SyslogError:
+		return logrus.ErrorLevel
+	case level == SyslogWarning:
+		return logrus.WarnLevel
+	case level == SyslogNotice, level == SyslogInformational:
+		return logrus.InfoLevel
 	default:
-		return LOG_DEBUG
+		return logrus.DebugLevel
 	}
 }
 
@@ -198,15 +382,37 @@ func (hook *GraylogHook) sendEntry(entry graylogEntry) {
 		full = p
 	}
 
-	level := logrusLevelToSyslog(entry.Level)
+	level := SyslogLevel(entry.Level)
+	if hook.LevelMapper != nil {
+		level = hook.LevelMapper(entry.Level)
+	}
 
 	// Don't modify entry.Data directly, as the entry will used after this hook was fired
 	extra := map[string]interface{}{}
+	// Merge local fields first, so that any other field overrides them
+	for k, v := range entry.localFields {
+		if hook.isBlacklisted(k) {
+			continue
+		}
+		k = fmt.Sprintf("_%s", k)
+		extra[k] = v
+	}
 	// Merge extra fields
 	for k, v := range hook.Extra {
+		if hook.isBlacklisted(k) {
+			continue
+		}
 		k = fmt.Sprintf("_%s", k) // "[...] every field you send and prefix with a _ (underscore) will be treated as an additional field."
 		extra[k] = v
 	}
+	// Merge context fields
+	for k, v := range entry.contextFields {
+		if hook.isBlacklisted(k) {
+			continue
+		}
+		k = fmt.Sprintf("_%s", k)
+		extra[k] = v
+	}
 
 	if entry.Caller != nil {
 		extra["_file"] = entry.Caller.File
@@ -215,8 +421,13 @@ func (hook *GraylogHook) sendEntry(entry graylogEntry) {
 	}
 
 	for k, v := range entry.Data {
-		if !hook.blacklist[k] {
-			extraK := fmt.Sprintf("_%s", k) // "[...] every field you send and prefix with a _ (underscore) will be treated as an additional field."
+		if !hook.isBlacklisted(k) {
+			extraK := fmt.Sprintf("_%s%s", hook.FieldPrefix, k) // "[...] every field you send and prefix with a _ (underscore) will be treated as an additional field."
+			if hook.FieldPrefix == "" && reservedFields[k] {
+				if _, warned := hook.warned.LoadOrStore(k, true); !warned {
+					log.Printf("graylog: field %q collides with a reserved GELF field, set FieldPrefix to avoid it", k)
+				}
+			}
 			if k == logrus.ErrorKey {
 				asError, isError := v.(error)
 				_, isMarshaler := v.(json.Marshaler)
@@ -229,13 +440,13 @@ func (hook *GraylogHook) sendEntry(entry graylogEntry) {
 					extra[StackTraceKey] = fmt.Sprintf("%+v", stackTrace)
 				}
 			} else {
-				extra[extraK] = v
+				extra[extraK] = typedValue(v)
 			}
 		}
 	}
 
 	m := Message{
-		Version:  "1.1",
+		Version:  GELFVersion,
 		Host:     hook.Host,
 		Short:    string(short),
 		Full:     string(full),
@@ -245,12 +456,74 @@ func (hook *GraylogHook) sendEntry(entry graylogEntry) {
 		Line:     entry.line,
 		Extra:    extra,
 	}
+	if hook.FacilityFunc != nil {
+		m.Facility = hook.FacilityFunc()
+	}
+	if err := checkFacility(&m, hook.strict); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if hook.SanitizeHostname {
+		m.SanitizeHost()
+	}
+
+	if hook.DeduplicationWindow > 0 {
+		hook.dedupOnce.Do(func() {
+			hook.dedup = newDedupCache(hook.DeduplicationWindow, hook.DeduplicationCacheSize, func(m *Message) {
+				hook.writeMessage(hook.gelfLogger, m)
+			})
+		})
+		if hook.dedup.suppress(&m) {
+			return
+		}
+	}
+	hook.writeMessage(w, &m)
+}
 
-	if err := w.WriteMessage(&m); err != nil {
+// writeMessage writes m with w, truncating it if the hook is configured to.
+func (hook *GraylogHook) writeMessage(w GELFWriter, m *Message) {
+	var err error
+	if hook.TruncateOnOverflow {
+		err = writeTruncated(w.WriteMessage, m)
+	} else {
+		err = w.WriteMessage(m)
+	}
+	if err != nil {
 		fmt.Println(err)
 	}
 }
 
+// typedValue converts v to the Go type of its kind, so that Graylog
+// indexes named numbers, booleans and strings with the right type. Errors
+// are converted to their message, and JSON or text marshalers are left
+// unchanged.
+func typedValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil, int, int64, float64, bool, string, json.Marshaler, encoding.TextMarshaler:
+		return v
+	case error:
+		return v.Error()
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return rv.Uint()
+	case reflect.Float32:
+		return float32(rv.Float())
+	case reflect.Float64:
+		return rv.Float()
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.String:
+		return rv.String()
+	default:
+		return v
+	}
+}
+
 // Levels returns the available logging levels.
 func (hook *GraylogHook) Levels() []logrus.Level {
 	levels := []logrus.Level{}
@@ -272,11 +545,97 @@ func (hook *GraylogHook) Blacklist(b []string) {
 	}
 }
 
+// isBlacklisted returns whether the field k must not be sent.
+func (hook *GraylogHook) isBlacklisted(k string) bool {
+	if hook.blacklist[k] {
+		return true
+	}
+	for _, f := range hook.BlacklistedFields {
+		if strings.EqualFold(f, k) {
+			return true
+		}
+	}
+	for _, re := range hook.BlacklistPatterns {
+		if re.MatchString(k) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithLocalFields registers a function called each time an entry is fired,
+// returning fields captured at log time (memory usage, goroutine count...).
+// They have the lowest priority: hook and entry fields with the same name
+// override them.
+func (hook *GraylogHook) WithLocalFields(fn func(*logrus.Entry) map[string]interface{}) *GraylogHook {
+	hook.localFields = fn
+	return hook
+}
+
+// WithCompression sets the compression of the hook writer, when it
+// implements ConfigurableWriter. Otherwise a warning is logged and the
+// writer is left unchanged.
+func (hook *GraylogHook) WithCompression(ct CompressType, level int) *GraylogHook {
+	if w, ok := hook.gelfLogger.(ConfigurableWriter); ok {
+		w.SetCompression(ct, level)
+	} else {
+		logrus.Warnf("Gelf writer %T doesn't support compression settings", hook.gelfLogger)
+	}
+	return hook
+}
+
+// SetMinLevel makes the hook send only the entries of level or more
+// severe. It can be called after the hook was added to a logger.
+func (hook *GraylogHook) SetMinLevel(level logrus.Level) *GraylogHook {
+	hook.mu.Lock()
+	defer hook.mu.Unlock()
+
+	hook.Level = level
+	return hook
+}
+
+// SetRateLimiter makes the hook drop the entries rl doesn't allow, counted
+// by DroppedCount, instead of sending them. A nil rl removes the limit.
+// It can be called after the hook was added to a logger.
+func (hook *GraylogHook) SetRateLimiter(rl RateLimiter) *GraylogHook {
+	hook.mu.Lock()
+	defer hook.mu.Unlock()
+
+	hook.rateLimiter = rl
+	return hook
+}
+
+// SetLevelRateLimiter is like SetRateLimiter, with a limit depending on
+// the entry level. When both are set, an entry must be allowed by rl first,
+// then by the rate limiter.
+func (hook *GraylogHook) SetLevelRateLimiter(rl LevelRateLimiter) *GraylogHook {
+	hook.mu.Lock()
+	defer hook.mu.Unlock()
+
+	hook.levelRates = rl
+	return hook
+}
+
+// DroppedCount returns the number of entries dropped by the rate limiters.
+func (hook *GraylogHook) DroppedCount() uint64 {
+	return atomic.LoadUint64(&hook.dropped)
+}
+
+// SetAsync wraps the hook writer in an AsyncWriter queuing up to queueSize
+// messages, so that logging doesn't wait for the network.
+func (hook *GraylogHook) SetAsync(queueSize int) *GraylogHook {
+	hook.gelfLogger = NewAsyncWriter(hook.gelfLogger, queueSize)
+	return hook
+}
+
 // SetWriter sets the hook Gelf writer
-func (hook *GraylogHook) SetWriter(w *UDPWriter) error {
+func (hook *GraylogHook) SetWriter(w GELFWriter) error {
 	if w == nil {
 		return errors.New("writer can't be nil")
 	}
+	if rv := reflect.ValueOf(w); rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return errors.New("writer can't be nil")
+	}
 	hook.gelfLogger = w
 	return nil
 }
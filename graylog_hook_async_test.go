@@ -0,0 +1,127 @@
+package graylog
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type blockingWriter struct {
+	release chan struct{}
+}
+
+func (w *blockingWriter) WriteMessage(m *Message) error {
+	<-w.release
+	return nil
+}
+
+func TestWaitForPendingMessages(t *testing.T) {
+	w := &blockingWriter{release: make(chan struct{})}
+	hook := NewAsyncGraylogHook("127.0.0.1:0", nil)
+	hook.gelfLogger = w
+
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	log.Hooks.Add(hook)
+	log.Info("pending message")
+
+	if err := hook.WaitForPendingMessages(10 * time.Millisecond); err != context.DeadlineExceeded {
+		t.Errorf("WaitForPendingMessages: expected %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	close(w.release)
+	if err := hook.WaitForPendingMessages(time.Second); err != nil {
+		t.Errorf("WaitForPendingMessages: %s", err)
+	}
+
+	// synchronous hooks have nothing to wait for
+	if err := NewGraylogHook("127.0.0.1:0", nil).WaitForPendingMessages(0); err != nil {
+		t.Errorf("WaitForPendingMessages: %s", err)
+	}
+}
+
+func TestWaitForPendingMessagesBlockedFire(t *testing.T) {
+	hook := NewAsyncGraylogHook("127.0.0.1:0", nil)
+
+	// like a Fire blocked on a full queue
+	hook.mu.RLock()
+	defer hook.mu.RUnlock()
+
+	result := make(chan error, 1)
+	go func() { result <- hook.WaitForPendingMessages(10 * time.Millisecond) }()
+	select {
+	case err := <-result:
+		if err != context.DeadlineExceeded {
+			t.Errorf("WaitForPendingMessages: expected %v, got %v", context.DeadlineExceeded, err)
+		}
+	case <-time.After(time.Second):
+		t.Error("WaitForPendingMessages should time out while a Fire holds the lock")
+	}
+}
+
+func TestSetAsync(t *testing.T) {
+	dest := &blockingWriter{release: make(chan struct{})}
+	hook := NewGraylogHook("127.0.0.1:0", nil)
+	hook.gelfLogger = dest
+	hook.SetAsync(10)
+
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	log.Hooks.Add(hook)
+	log.Info("queued message")
+
+	if err := hook.WaitForPendingMessages(10 * time.Millisecond); err != context.DeadlineExceeded {
+		t.Errorf("WaitForPendingMessages: expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	close(dest.release)
+	if err := hook.WaitForPendingMessages(time.Second); err != nil {
+		t.Errorf("WaitForPendingMessages: %s", err)
+	}
+	hook.Writer().(*AsyncWriter).Close()
+}
+
+// TestSetAsyncConcurrentFire is meant to be run with -race
+func TestSetAsyncConcurrentFire(t *testing.T) {
+	const goroutines, perGoroutine = 8, 50
+	dest := &flakyWriter{}
+	hook := NewGraylogHook("127.0.0.1:0", nil)
+	hook.gelfLogger = dest
+	hook.SetAsync(goroutines * perGoroutine) // AsyncWriter drops messages when full
+	defer hook.Writer().(*AsyncWriter).Close()
+
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	log.Hooks.Add(hook)
+
+	var wg sync.WaitGroup
+	var expected []string
+	for g := 0; g < goroutines; g++ {
+		for i := 0; i < perGoroutine; i++ {
+			expected = append(expected, fmt.Sprintf("goroutine %d message %03d", g, i))
+		}
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < perGoroutine; i++ {
+				log.WithField("goroutine", g).Infof("goroutine %d message %03d", g, i)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if err := hook.WaitForPendingMessages(5 * time.Second); err != nil {
+		t.Fatalf("WaitForPendingMessages: %s", err)
+	}
+	got := dest.messages()
+	sort.Strings(got)
+	sort.Strings(expected)
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("expected the %d messages logged, got %d", len(expected), len(got))
+	}
+}
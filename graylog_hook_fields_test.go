@@ -0,0 +1,165 @@
+package graylog
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestFieldPrefix(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+	hook := NewGraylogHook(r.Addr(), map[string]interface{}{"env": "test"})
+
+	var warnings bytes.Buffer
+	log.SetOutput(&warnings)
+	defer log.SetOutput(os.Stderr)
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	logger.Hooks.Add(hook)
+
+	logger.WithField("level", "high").Info("collision")
+	logger.WithField("level", "high").Info("collision")
+	if _, err := r.ReadMessage(); err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+	if _, err := r.ReadMessage(); err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+	if n := strings.Count(warnings.String(), `"level" collides`); n != 1 {
+		t.Errorf("expected 1 collision warning, got %d: %s", n, warnings.String())
+	}
+
+	warnings.Reset()
+	hook.FieldPrefix = "app_"
+	logger.WithField("level", "high").Info("prefixed")
+	msg, err := r.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+	if msg.Extra["_app_level"] != "high" {
+		t.Errorf("expected _app_level to be set, got %v", msg.Extra)
+	}
+	if _, ok := msg.Extra["_level"]; ok {
+		t.Error("expected _level not to be set with a prefix")
+	}
+	if msg.Extra["_env"] != "test" {
+		t.Errorf("expected the hook fields not to be prefixed, got %v", msg.Extra)
+	}
+	if warnings.Len() != 0 {
+		t.Errorf("expected no warning with a prefix, got %s", warnings.String())
+	}
+}
+
+func TestBlacklistedFields(t *testing.T) {
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ = ioutil.ReadAll(req.Body)
+		rw.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	hook := NewGraylogHook(server.URL, map[string]interface{}{"api_token": "t0k3n", "env": "test"})
+	hook.BlacklistedFields = []string{"Password", "api_token"}
+	hook.BlacklistPatterns = []*regexp.Regexp{regexp.MustCompile(`_secret$`)}
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	logger.Hooks.Add(hook)
+	logger.WithFields(logrus.Fields{
+		"password":      "hunter2",
+		"password_hint": "pet name",
+		"client_secret": "s3cr3t",
+		"user":          "alice",
+	}).Info("login")
+
+	for _, leaked := range []string{"hunter2", "t0k3n", "s3cr3t"} {
+		if strings.Contains(string(body), leaked) {
+			t.Errorf("expected %q to be blacklisted, got %s", leaked, body)
+		}
+	}
+	for _, kept := range []string{`"_password_hint"`, `"_user"`, `"_env"`} {
+		if !strings.Contains(string(body), kept) {
+			t.Errorf("expected %s to be sent, got %s", kept, body)
+		}
+	}
+}
+
+func TestHookFacilityFunc(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+	hook := NewGraylogHook(r.Addr(), nil)
+	hook.FacilityFunc = func() string { return "checkout" }
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	logger.Hooks.Add(hook)
+	logger.Info("dynamic facility")
+
+	msg, err := r.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+	if msg.Facility != "checkout" {
+		t.Errorf("expected facility checkout, got %q", msg.Facility)
+	}
+}
+
+type contextFieldsKey struct{}
+
+func TestContextExtractor(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+	hook := NewGraylogHook(r.Addr(), map[string]interface{}{"trace_id": "static", "env": "prod"})
+	hook.ContextExtractor = func(ctx context.Context) map[string]interface{} {
+		fields, _ := ctx.Value(contextFieldsKey{}).(map[string]interface{})
+		return fields
+	}
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	logger.Hooks.Add(hook)
+	ctx := context.WithValue(context.Background(), contextFieldsKey{}, map[string]interface{}{
+		"trace_id": "4bf92f35", "user_id": "u-42",
+	})
+	logger.WithContext(ctx).WithField("user_id", "u-7").Info("with context")
+	logger.Info("without context")
+
+	msg, err := r.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+	expected := map[string]interface{}{"_trace_id": "4bf92f35", "_user_id": "u-7", "_env": "prod"}
+	for k, v := range expected {
+		if msg.Extra[k] != v {
+			t.Errorf("%s: expected %v, got %v", k, v, msg.Extra[k])
+		}
+	}
+
+	msg, err = r.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+	if msg.Extra["_trace_id"] != "static" {
+		t.Errorf("_trace_id: expected static, got %v", msg.Extra["_trace_id"])
+	}
+}
@@ -300,7 +300,7 @@ runtime.*
 	}
 }
 
-func TestLogrusLevelToSyslog(t *testing.T) {
+func TestSyslogLevel(t *testing.T) {
 	// Syslog constants
 	const (
 		LOG_EMERG   = 0 /* system is unusable */
@@ -313,32 +313,32 @@ func TestLogrusLevelToSyslog(t *testing.T) {
 		LOG_DEBUG   = 7 /* debug-level messages */
 	)
 
-	if logrusLevelToSyslog(logrus.TraceLevel) != LOG_DEBUG {
-		t.Error("logrusLevelToSyslog(TraceLevel) != LOG_DEBUG")
+	if SyslogLevel(logrus.TraceLevel) != LOG_DEBUG {
+		t.Error("SyslogLevel(TraceLevel) != LOG_DEBUG")
 	}
 
-	if logrusLevelToSyslog(logrus.DebugLevel) != LOG_DEBUG {
-		t.Error("logrusLevelToSyslog(DebugLevel) != LOG_DEBUG")
+	if SyslogLevel(logrus.DebugLevel) != LOG_DEBUG {
+		t.Error("SyslogLevel(DebugLevel) != LOG_DEBUG")
 	}
 
-	if logrusLevelToSyslog(logrus.InfoLevel) != LOG_INFO {
-		t.Error("logrusLevelToSyslog(InfoLevel) != LOG_INFO")
+	if SyslogLevel(logrus.InfoLevel) != LOG_INFO {
+		t.Error("SyslogLevel(InfoLevel) != LOG_INFO")
 	}
 
-	if logrusLevelToSyslog(logrus.WarnLevel) != LOG_WARNING {
-		t.Error("logrusLevelToSyslog(WarnLevel) != LOG_WARNING")
+	if SyslogLevel(logrus.WarnLevel) != LOG_WARNING {
+		t.Error("SyslogLevel(WarnLevel) != LOG_WARNING")
 	}
 
-	if logrusLevelToSyslog(logrus.ErrorLevel) != LOG_ERR {
-		t.Error("logrusLevelToSyslog(ErrorLevel) != LOG_ERR")
+	if SyslogLevel(logrus.ErrorLevel) != LOG_ERR {
+		t.Error("SyslogLevel(ErrorLevel) != LOG_ERR")
 	}
 
-	if logrusLevelToSyslog(logrus.FatalLevel) != LOG_CRIT {
-		t.Error("logrusLevelToSyslog(FatalLevel) != LOG_CRIT")
+	if SyslogLevel(logrus.FatalLevel) != LOG_CRIT {
+		t.Error("SyslogLevel(FatalLevel) != LOG_CRIT")
 	}
 
-	if logrusLevelToSyslog(logrus.PanicLevel) != LOG_ALERT {
-		t.Error("logrusLevelToSyslog(PanicLevel) != LOG_ALERT")
+	if SyslogLevel(logrus.PanicLevel) != LOG_ALERT {
+		t.Error("SyslogLevel(PanicLevel) != LOG_ALERT")
 	}
 }
 
@@ -383,14 +383,14 @@ func TestReportCallerEnabled(t *testing.T) {
 		t.Error("_line field not present in extra fields")
 	}
 
-	lineGot, ok := lineField.(float64)
+	lineGot, ok := lineField.(json.Number)
 	if !ok {
 		t.Error("_line dowes not have the correct type")
 	}
 
 	lineExpected := 358 // Update this if code is updated above
 	if msg.Line != lineExpected {
-		t.Errorf("msg.Extra[\"_line\"]: expected %d, got %d", lineExpected, int(lineGot))
+		t.Errorf("msg.Extra[\"_line\"]: expected %d, got %s", lineExpected, lineGot)
 	}
 
 	functionField, ok := msg.Extra["_function"]
@@ -500,3 +500,244 @@ func TestHTTPWriter(t *testing.T) {
 	log.Hooks.Add(hook)
 	log.Info(msgData)
 }
+
+func TestWithLocalFields(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+
+	calls := 0
+	hook := NewGraylogHook(r.Addr(), map[string]interface{}{"foo": "bar"}).
+		WithLocalFields(func(entry *logrus.Entry) map[string]interface{} {
+			calls++
+			if calls > 1 {
+				return nil
+			}
+			return map[string]interface{}{"goroutines": 12, "foo": "local", "withField": "local"}
+		})
+
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	log.Hooks.Add(hook)
+	log.WithField("withField", "1").Info("test message")
+
+	msg, err := r.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+	if calls != 1 {
+		t.Errorf("local fields function: expected 1 call, got %d", calls)
+	}
+	if msg.Extra["_goroutines"] != json.Number("12") {
+		t.Errorf("_goroutines: expected 12, got %v", msg.Extra["_goroutines"])
+	}
+	if msg.Extra["_foo"] != "bar" {
+		t.Errorf("_foo: hook extra should override local fields, got %v", msg.Extra["_foo"])
+	}
+	if msg.Extra["_withField"] != "1" {
+		t.Errorf("_withField: entry fields should override local fields, got %v", msg.Extra["_withField"])
+	}
+
+	// nil local fields are ignored
+	log.Info("second message")
+	if _, err := r.ReadMessage(); err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+	if calls != 2 {
+		t.Errorf("local fields function: expected 2 calls, got %d", calls)
+	}
+}
+
+func TestWithCompression(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	hook := NewGraylogHook(r.Addr(), nil).WithCompression(CompressZlib, flate.BestCompression)
+
+	w := hook.Writer().(*UDPWriter)
+	if w.CompressionType != CompressZlib || w.CompressionLevel != flate.BestCompression {
+		t.Errorf("compression: expected %d/%d, got %d/%d", CompressZlib, flate.BestCompression,
+			w.CompressionType, w.CompressionLevel)
+	}
+
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	log.Hooks.Add(hook)
+	log.Info("compressed message")
+	if msg, err := r.ReadMessage(); err != nil || msg.Short != "compressed message" {
+		t.Errorf("ReadMessage: expected compressed message, got %v (%v)", msg, err)
+	}
+
+	// HTTP writers don't support compression: no-op
+	httpHook := NewGraylogHook("http://127.0.0.1:12201/gelf", nil)
+	if httpHook.WithCompression(CompressZlib, flate.BestCompression) != httpHook {
+		t.Error("WithCompression should return the hook")
+	}
+}
+
+func TestHookSanitizeHostname(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	hook := NewGraylogHook(r.Addr(), nil)
+	hook.Host = "API_7d9f.Billing.EXAMPLE"
+	hook.SanitizeHostname = true
+
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	log.Hooks.Add(hook)
+	log.Info("sanitized")
+
+	msg, err := r.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+	if msg.Host != "api-7d9f.billing.example" {
+		t.Errorf("msg.Host: expected api-7d9f.billing.example, got %s", msg.Host)
+	}
+}
+
+func TestSetMinLevel(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+	hook := NewGraylogHook(r.Addr(), nil)
+
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	log.Level = logrus.DebugLevel
+	log.Hooks.Add(hook)
+
+	if hook.SetMinLevel(logrus.WarnLevel) != hook {
+		t.Error("SetMinLevel should return the hook")
+	}
+	levels := hook.Levels()
+	if len(levels) != 4 || levels[len(levels)-1] != logrus.WarnLevel {
+		t.Errorf("Levels: expected panic to warn, got %v", levels)
+	}
+
+	log.Debug("filtered")
+	log.Info("filtered")
+	log.Warn("sent")
+	msg, err := r.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+	if msg.Short != "sent" {
+		t.Errorf("expected the entries below warn to be filtered, got %q", msg.Short)
+	}
+}
+
+func TestLevelMapper(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+	hook := NewGraylogHook(r.Addr(), nil)
+	hook.LevelMapper = func(level logrus.Level) int32 {
+		if level == logrus.PanicLevel {
+			return SyslogEmergency
+		}
+		return SyslogLevel(level)
+	}
+
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	log.Hooks.Add(hook)
+	func() {
+		defer func() { recover() }()
+		log.Panic("mapped")
+	}()
+
+	msg, err := r.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+	if msg.Level != SyslogEmergency {
+		t.Errorf("expected level %d from the LevelMapper, got %d", SyslogEmergency, msg.Level)
+	}
+}
+
+func TestCallerDepth(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+	hook := NewGraylogHook(r.Addr(), nil)
+	hook.CallerDepth = 10
+
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	log.Hooks.Add(hook)
+	_, file, line, _ := runtime.Caller(0)
+	log.WithField("found", true).Info("caller")
+
+	msg, err := r.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+	if msg.File != file || msg.Line != line+1 {
+		t.Errorf("expected caller %s:%d, got %s:%d", file, line+1, msg.File, msg.Line)
+	}
+
+	hook.CallerDepth = 0
+	log.Info("no caller")
+	if msg, err = r.ReadMessage(); err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+	if msg.File != "" || msg.Line != 0 {
+		t.Errorf("expected no caller when CallerDepth is 0, got %s:%d", msg.File, msg.Line)
+	}
+}
+
+type typedFieldsUserID int
+
+func TestTypedExtraValues(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+	hook := NewGraylogHook(r.Addr(), nil)
+
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	log.Hooks.Add(hook)
+	log.WithFields(logrus.Fields{
+		"user_id": typedFieldsUserID(42),
+		"cause":   errors.New("timeout"),
+		"cached":  true,
+		"ratio":   0.5,
+	}).Info("typed")
+
+	msg, err := r.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+	expected := map[string]interface{}{
+		"_user_id": json.Number("42"), "_cause": "timeout", "_cached": true, "_ratio": json.Number("0.5"),
+	}
+	for k, v := range expected {
+		if msg.Extra[k] != v {
+			t.Errorf("%s: expected %#v, got %#v", k, v, msg.Extra[k])
+		}
+	}
+
+	for v, expected := range map[interface{}]interface{}{
+		typedFieldsUserID(7): int64(7),
+		uint8(3):             uint64(3),
+		errors.New("boom"):   "boom",
+		"plain":              "plain",
+	} {
+		if typed := typedValue(v); typed != expected {
+			t.Errorf("typedValue(%#v): expected %#v, got %#v", v, expected, typed)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Package graylogtest provides helpers to test the GELF messages sent by an
+// application.
+package graylogtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	graylog "github.com/gemnasium/logrus-graylog-hook/v3"
+)
+
+// AssertMessageFields checks that msg has the expected fields, and reports
+// all the mismatches in a single error. Keys starting with "_" are looked up
+// in msg.Extra, other keys are the GELF names of the named fields, like
+// "short_message" or "level". Numbers of different types, json.Number
+// included, are equal when their values are. It returns whether all fields
+// matched.
+func AssertMessageFields(t testing.TB, msg *graylog.Message, expected map[string]interface{}) bool {
+	t.Helper()
+
+	var mismatches []string
+	for _, k := range sortedKeys(expected) {
+		got, ok := field(msg, k)
+		if !ok {
+			mismatches = append(mismatches, fmt.Sprintf("%s: missing, expected %#v", k, expected[k]))
+		} else if !equal(got, expected[k]) {
+			mismatches = append(mismatches, fmt.Sprintf("%s: expected %#v, got %#v", k, expected[k], got))
+		}
+	}
+
+	if len(mismatches) > 0 {
+		t.Errorf("message %q doesn't have the expected fields:\n%s", msg.Short, strings.Join(mismatches, "\n"))
+		return false
+	}
+	return true
+}
+
+// RequireMessageFields is AssertMessageFields, stopping the test with
+// t.FailNow on mismatch.
+func RequireMessageFields(t testing.TB, msg *graylog.Message, expected map[string]interface{}) {
+	t.Helper()
+
+	if !AssertMessageFields(t, msg, expected) {
+		t.FailNow()
+	}
+}
+
+func field(msg *graylog.Message, k string) (interface{}, bool) {
+	switch k {
+	case "version":
+		return msg.Version, true
+	case "host":
+		return msg.Host, true
+	case "short_message":
+		return msg.Short, true
+	case "full_message":
+		return msg.Full, true
+	case "timestamp":
+		return msg.TimeUnix, true
+	case "level":
+		return msg.Level, true
+	case "facility":
+		return msg.Facility, true
+	case "file":
+		return msg.File, true
+	case "line":
+		return msg.Line, true
+	}
+	v, ok := msg.Extra[k]
+	return v, ok
+}
+
+// equal compares the integers exactly, as float64 values can't hold the
+// ones above 2^53, and the other numbers as float64 values
+func equal(got, expected interface{}) bool {
+	if g, ok := toInteger(got); ok {
+		if e, ok := toInteger(expected); ok {
+			return g == e
+		}
+	}
+	if g, ok := toFloat64(got); ok {
+		if e, ok := toFloat64(expected); ok {
+			return g == e
+		}
+	}
+	return reflect.DeepEqual(got, expected)
+}
+
+// toInteger returns the decimal representation of the integer v
+func toInteger(v interface{}) (string, bool) {
+	if n, ok := v.(json.Number); ok {
+		if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+			return strconv.FormatInt(i, 10), true
+		}
+		if u, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+			return strconv.FormatUint(u, 10), true
+		}
+		return "", false
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10), true
+	}
+	return "", false
+}
+
+func toFloat64(v interface{}) (float64, bool) {
+	// decoded messages hold json.Number values
+	if n, ok := v.(json.Number); ok {
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,99 @@
+package graylogtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	graylog "github.com/gemnasium/logrus-graylog-hook/v3"
+)
+
+// recordingT records the failures instead of reporting them
+type recordingT struct {
+	testing.TB
+	errors []string
+	failed bool
+}
+
+func (t *recordingT) Helper() {}
+
+func (t *recordingT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func (t *recordingT) FailNow() {
+	t.failed = true
+}
+
+func TestAssertMessageFields(t *testing.T) {
+	msg := graylog.Logf(graylog.SyslogError, "payment declined").
+		AppendExtra("user_id", 7).
+		AppendExtra("env", "prod").
+		AppendExtra("amount", json.Number("12.5"))
+
+	rt := &recordingT{TB: t}
+	if !AssertMessageFields(rt, msg, map[string]interface{}{
+		"short_message": "payment declined",
+		"level":         3,
+		"_user_id":      int64(7),
+		"_env":          "prod",
+		"_amount":       12.5,
+	}) || len(rt.errors) != 0 {
+		t.Errorf("AssertMessageFields: unexpected errors %v", rt.errors)
+	}
+
+	rt = &recordingT{TB: t}
+	AssertMessageFields(rt, msg, map[string]interface{}{
+		"level":    4,
+		"_env":     "staging",
+		"_missing": true,
+	})
+	if len(rt.errors) != 1 {
+		t.Fatalf("AssertMessageFields: expected a single error, got %v", rt.errors)
+	}
+	for _, k := range []string{"level", "_env", "_missing"} {
+		if !strings.Contains(rt.errors[0], k) {
+			t.Errorf("AssertMessageFields: expected %s in %q", k, rt.errors[0])
+		}
+	}
+	if rt.failed {
+		t.Error("AssertMessageFields should not stop the test")
+	}
+}
+
+func TestAssertMessageFieldsLargeIntegers(t *testing.T) {
+	msg := graylog.Logf(graylog.SyslogInformational, "ids").
+		AppendExtra("request_id", json.Number("9007199254740993")).
+		AppendExtra("trace_id", uint64(18446744073709551615))
+
+	rt := &recordingT{TB: t}
+	if !AssertMessageFields(rt, msg, map[string]interface{}{
+		"_request_id": int64(9007199254740993),
+		"_trace_id":   json.Number("18446744073709551615"),
+	}) {
+		t.Errorf("AssertMessageFields: unexpected errors %v", rt.errors)
+	}
+
+	// equal as float64 values
+	rt = &recordingT{TB: t}
+	if AssertMessageFields(rt, msg, map[string]interface{}{
+		"_request_id": int64(9007199254740992),
+		"_trace_id":   json.Number("18446744073709551614"),
+	}) {
+		t.Error("AssertMessageFields: expected integers above 2^53 to be compared exactly")
+	}
+	if len(rt.errors) != 1 || !strings.Contains(rt.errors[0], "_request_id") || !strings.Contains(rt.errors[0], "_trace_id") {
+		t.Errorf("AssertMessageFields: expected both mismatches, got %v", rt.errors)
+	}
+}
+
+func TestRequireMessageFields(t *testing.T) {
+	msg := graylog.Logf(graylog.SyslogError, "payment declined")
+
+	rt := &recordingT{TB: t}
+	RequireMessageFields(rt, msg, map[string]interface{}{"short_message": "payment accepted"})
+	if !rt.failed || len(rt.errors) != 1 {
+		t.Errorf("RequireMessageFields: expected the test to be stopped, got %v", rt.errors)
+	}
+}
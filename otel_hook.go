@@ -0,0 +1,39 @@
+//go:build otel
+// +build otel
+
+package graylog
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WithOTelTraceExtraction makes the hook add the IDs of the OpenTelemetry
+// span active in the context of the entries, see logrus.WithContext, as the
+// _trace_id and _span_id fields, to correlate the logs with the traces
+// sent to Jaeger or Zipkin. The ContextExtractor already set, if any, is
+// still called. It requires building with the otel tag.
+func (hook *GraylogHook) WithOTelTraceExtraction() *GraylogHook {
+	next := hook.ContextExtractor
+	hook.ContextExtractor = func(ctx context.Context) map[string]interface{} {
+		var fields map[string]interface{}
+		if next != nil {
+			fields = next(ctx)
+		}
+		sc := trace.SpanContextFromContext(ctx)
+		if !sc.IsValid() {
+			return fields
+		}
+
+		// don't modify the map returned by next, it may be reused
+		traced := make(map[string]interface{}, len(fields)+2)
+		for k, v := range fields {
+			traced[k] = v
+		}
+		traced["trace_id"] = sc.TraceID().String()
+		traced["span_id"] = sc.SpanID().String()
+		return traced
+	}
+	return hook
+}
@@ -0,0 +1,59 @@
+//go:build otel
+// +build otel
+
+package graylog
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestWithOTelTraceExtraction(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+	hook := NewGraylogHook(r.Addr(), nil)
+	hook.ContextExtractor = func(ctx context.Context) map[string]interface{} {
+		return map[string]interface{}{"tenant": "acme"}
+	}
+	hook.WithOTelTraceExtraction()
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	logger.Hooks.Add(hook)
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:  trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+	})
+	logger.WithContext(trace.ContextWithSpanContext(context.Background(), sc)).Info("traced")
+	logger.WithContext(context.Background()).Info("untraced")
+
+	msg, err := r.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+	expected := map[string]interface{}{
+		"_trace_id": "4bf92f3577b34da6a3ce929d0e0e4736",
+		"_span_id":  "00f067aa0ba902b7",
+		"_tenant":   "acme",
+	}
+	for k, v := range expected {
+		if msg.Extra[k] != v {
+			t.Errorf("%s: expected %v, got %v", k, v, msg.Extra[k])
+		}
+	}
+
+	msg, err = r.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+	if _, ok := msg.Extra["_trace_id"]; ok || msg.Extra["_tenant"] != "acme" {
+		t.Errorf("expected only the _tenant field, got %v", msg.Extra)
+	}
+}
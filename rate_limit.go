@@ -0,0 +1,68 @@
+package graylog
+
+import (
+	"math"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// RateLimiter decides whether the hook sends an entry, to keep an
+// application stuck in an error loop from flooding the network and
+// Graylog. See GraylogHook.SetRateLimiter.
+type RateLimiter interface {
+	// Allow reports whether an entry may be sent now.
+	Allow() bool
+}
+
+// TokenBucketLimiter is a RateLimiter allowing rps entries per second on
+// average, in bursts of up to burst entries.
+type TokenBucketLimiter struct {
+	limiter *rate.Limiter
+}
+
+// NewTokenBucketLimiter returns a TokenBucketLimiter allowing rps entries
+// per second, in bursts of up to burst entries. A burst of 0 allows none.
+func NewTokenBucketLimiter(rps float64, burst int) *TokenBucketLimiter {
+	return &TokenBucketLimiter{limiter: rate.NewLimiter(rate.Limit(rps), burst)}
+}
+
+// Allow implements the RateLimiter interface
+func (l *TokenBucketLimiter) Allow() bool {
+	return l.limiter.Allow()
+}
+
+// LevelRateLimiter decides whether the hook sends an entry depending on
+// its level. See GraylogHook.SetLevelRateLimiter.
+type LevelRateLimiter interface {
+	// AllowLevel reports whether an entry of level may be sent now.
+	AllowLevel(level logrus.Level) bool
+}
+
+// PerLevelRateLimiter is a LevelRateLimiter with a limit per level, to
+// drop the Debug noise without losing the errors, like the sampling of
+// zap.
+type PerLevelRateLimiter struct {
+	limiters map[logrus.Level]*rate.Limiter
+}
+
+// NewPerLevelRateLimiter returns a PerLevelRateLimiter allowing limits[l]
+// entries per second of each level l, in bursts of up to a second of
+// entries, at least one. The levels missing from limits are unlimited.
+func NewPerLevelRateLimiter(limits map[logrus.Level]rate.Limit) *PerLevelRateLimiter {
+	l := &PerLevelRateLimiter{limiters: make(map[logrus.Level]*rate.Limiter, len(limits))}
+	for level, limit := range limits {
+		burst := int(math.Ceil(float64(limit)))
+		if burst < 1 || limit == rate.Inf {
+			burst = 1
+		}
+		l.limiters[level] = rate.NewLimiter(limit, burst)
+	}
+	return l
+}
+
+// AllowLevel implements the LevelRateLimiter interface
+func (l *PerLevelRateLimiter) AllowLevel(level logrus.Level) bool {
+	limiter, ok := l.limiters[level]
+	return !ok || limiter.Allow()
+}
@@ -0,0 +1,107 @@
+package graylog
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+func TestTokenBucketLimiter(t *testing.T) {
+	l := NewTokenBucketLimiter(0.001, 2)
+	for i, expected := range []bool{true, true, false, false} {
+		if got := l.Allow(); got != expected {
+			t.Errorf("Allow #%d: expected %t, got %t", i, expected, got)
+		}
+	}
+}
+
+func TestSetRateLimiter(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+	hook := NewGraylogHook(r.Addr(), nil).SetRateLimiter(NewTokenBucketLimiter(0.001, 2))
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	logger.Hooks.Add(hook)
+	for i := 0; i < 5; i++ {
+		logger.Errorf("error loop %d", i)
+	}
+
+	for i := 0; i < 2; i++ {
+		msg, err := r.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage: %s", err)
+		}
+		if expected := fmt.Sprintf("error loop %d", i); msg.Short != expected {
+			t.Errorf("expected %q, got %q", expected, msg.Short)
+		}
+	}
+	if got := hook.DroppedCount(); got != 3 {
+		t.Errorf("expected 3 dropped entries, got %d", got)
+	}
+
+	hook.SetRateLimiter(nil)
+	logger.Error("unlimited")
+	msg, err := r.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+	if msg.Short != "unlimited" || hook.DroppedCount() != 3 {
+		t.Errorf("expected the entry to be sent, got %q with %d dropped", msg.Short, hook.DroppedCount())
+	}
+}
+
+func TestPerLevelRateLimiter(t *testing.T) {
+	l := NewPerLevelRateLimiter(map[logrus.Level]rate.Limit{
+		logrus.DebugLevel: 0.001,
+		logrus.InfoLevel:  rate.Inf,
+	})
+	for i := 0; i < 3; i++ {
+		if !l.AllowLevel(logrus.InfoLevel) || !l.AllowLevel(logrus.ErrorLevel) {
+			t.Errorf("#%d: info and error entries should be allowed", i)
+		}
+		if got := l.AllowLevel(logrus.DebugLevel); got != (i == 0) {
+			t.Errorf("#%d: debug entry allowed %t", i, got)
+		}
+	}
+}
+
+func TestSetLevelRateLimiter(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+	hook := NewGraylogHook(r.Addr(), nil)
+	hook.Level = logrus.DebugLevel
+	hook.SetLevelRateLimiter(NewPerLevelRateLimiter(map[logrus.Level]rate.Limit{logrus.DebugLevel: 0.001}))
+	hook.SetRateLimiter(NewTokenBucketLimiter(0.001, 2))
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	logger.Level = logrus.DebugLevel
+	logger.Hooks.Add(hook)
+	for i := 0; i < 3; i++ {
+		logger.Debugf("noise %d", i)
+	}
+	logger.Error("error")
+
+	for _, expected := range []string{"noise 0", "error"} {
+		msg, err := r.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage: %s", err)
+		}
+		if msg.Short != expected {
+			t.Errorf("expected %q, got %q", expected, msg.Short)
+		}
+	}
+	if got := hook.DroppedCount(); got != 2 {
+		t.Errorf("expected 2 dropped entries, got %d", got)
+	}
+}
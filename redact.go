@@ -0,0 +1,51 @@
+package graylog
+
+import (
+	"regexp"
+)
+
+// Redacted replaces the values masked by a RegexpRedactor
+const Redacted = "[REDACTED]"
+
+// Redactor masks sensitive additional field values, like credit card
+// numbers or API keys, before a message leaves the process. Redact returns
+// the value to send for the field key.
+type Redactor interface {
+	Redact(key string, value interface{}) interface{}
+}
+
+// RegexpRedactor replaces the parts of string values matching one of its
+// Patterns with Redacted. Other values are sent unchanged.
+type RegexpRedactor struct {
+	Patterns []*regexp.Regexp
+}
+
+// NewRegexpRedactor returns a RegexpRedactor masking patterns.
+func NewRegexpRedactor(patterns ...*regexp.Regexp) *RegexpRedactor {
+	return &RegexpRedactor{Patterns: patterns}
+}
+
+// Redact implements Redactor
+func (r *RegexpRedactor) Redact(key string, value interface{}) interface{} {
+	s, ok := value.(string)
+	if !ok {
+		return value
+	}
+	for _, re := range r.Patterns {
+		s = re.ReplaceAllString(s, Redacted)
+	}
+	return s
+}
+
+// redactExtra returns m, or a copy of m with its additional fields passed
+// through r, so that the caller's message is left unchanged.
+func redactExtra(m *Message, r Redactor) *Message {
+	if r == nil || len(m.Extra) == 0 {
+		return m
+	}
+	c := m.Clone()
+	for k, v := range c.Extra {
+		c.Extra[k] = r.Redact(k, v)
+	}
+	return c
+}
@@ -0,0 +1,77 @@
+package graylog
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var cardNumber = regexp.MustCompile(`\b\d{4}( ?\d{4}){3}\b`)
+
+func TestRegexpRedactor(t *testing.T) {
+	r := NewRegexpRedactor(cardNumber, regexp.MustCompile(`sk_live_\w+`))
+	for value, expected := range map[interface{}]interface{}{
+		"paid with 4242 4242 4242 4242": "paid with [REDACTED]",
+		"key sk_live_abc123 rotated":    "key [REDACTED] rotated",
+		"nothing to hide":               "nothing to hide",
+		int64(4242424242424242):         int64(4242424242424242),
+	} {
+		if got := r.Redact("_note", value); got != expected {
+			t.Errorf("Redact(%v): expected %v, got %v", value, expected, got)
+		}
+	}
+}
+
+func TestUDPWriterSetRedactor(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+	w, err := NewWriter(r.Addr())
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	w.(*UDPWriter).SetRedactor(NewRegexpRedactor(cardNumber))
+
+	m := Logf(SyslogInformational, "payment").AppendExtra("card", "4242424242424242")
+	if err := w.WriteMessage(m); err != nil {
+		t.Fatalf("WriteMessage: %s", err)
+	}
+	if m.Extra["_card"] != "4242424242424242" {
+		t.Errorf("WriteMessage should not modify the message, got %v", m.Extra["_card"])
+	}
+
+	msg, err := r.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+	if msg.Extra["_card"] != Redacted {
+		t.Errorf("_card: expected %s, got %v", Redacted, msg.Extra["_card"])
+	}
+}
+
+func TestHTTPWriterSetRedactor(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		b, _ := ioutil.ReadAll(req.Body)
+		body = string(b)
+		rw.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	w, err := NewHTTPWriter(server.URL, nil)
+	if err != nil {
+		t.Fatalf("NewHTTPWriter: %s", err)
+	}
+	w.(*HTTPWriter).SetRedactor(NewRegexpRedactor(cardNumber))
+	if err := w.WriteMessage(Logf(SyslogInformational, "payment").AppendExtra("card", "4242 4242 4242 4242")); err != nil {
+		t.Fatalf("WriteMessage: %s", err)
+	}
+	if strings.Contains(body, "4242") || !strings.Contains(body, `"_card":"[REDACTED]"`) {
+		t.Errorf("expected the card number to be redacted, got %s", body)
+	}
+}
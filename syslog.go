@@ -0,0 +1,24 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package graylog
+
+import "log/syslog"
+
+// syslogFacilities names the syslog facility codes, as listed in RFC 5424
+var syslogFacilities = [...]string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "audit", "alert", "clock",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+// NewMessageFromSyslogPriority builds a message from a log/syslog priority,
+// such as syslog.LOG_ERR|syslog.LOG_LOCAL0. The severity becomes the
+// message level and the facility its name, like "local0".
+func NewMessageFromSyslogPriority(priority syslog.Priority, msg string) *Message {
+	m := Logf(int32(priority&0x07), "%s", msg)
+	if facility := int(priority >> 3); facility < len(syslogFacilities) {
+		m.Facility = syslogFacilities[facility]
+	}
+	return m
+}
@@ -0,0 +1,35 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package graylog
+
+import (
+	"log/syslog"
+	"testing"
+)
+
+func TestNewMessageFromSyslogPriority(t *testing.T) {
+	tests := []struct {
+		priority syslog.Priority
+		level    int32
+		facility string
+	}{
+		{syslog.LOG_ERR | syslog.LOG_LOCAL0, 3, "local0"},
+		{syslog.LOG_DEBUG | syslog.LOG_LOCAL7, 7, "local7"},
+		{syslog.LOG_WARNING | syslog.LOG_DAEMON, 4, "daemon"},
+		{syslog.LOG_EMERG | syslog.LOG_KERN, 0, "kern"},
+		{syslog.LOG_INFO | syslog.LOG_AUTHPRIV, 6, "authpriv"},
+	}
+	for _, test := range tests {
+		m := NewMessageFromSyslogPriority(test.priority, "disk full")
+		if m.Level != test.level {
+			t.Errorf("priority %d: expected level %d, got %d", test.priority, test.level, m.Level)
+		}
+		if m.Facility != test.facility {
+			t.Errorf("priority %d: expected facility %s, got %s", test.priority, test.facility, m.Facility)
+		}
+		if m.Short != "disk full" {
+			t.Errorf("priority %d: expected short message %q, got %q", test.priority, "disk full", m.Short)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package graylog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Set graylog.StrictMode = true _before_ creating writers or hooks to
+// validate their settings and the messages they send.
+var StrictMode = false
+
+// maxFacilityLen is the longest facility accepted by ValidateFacility.
+const maxFacilityLen = 128
+
+// ValidateFacility reports whether s can be safely used as a GELF facility
+// in Graylog stream rules: it must be non-empty, at most 128 bytes long and
+// free of control characters.
+func ValidateFacility(s string) error {
+	if s == "" {
+		return errors.New("facility can't be empty")
+	}
+	if len(s) > maxFacilityLen {
+		return fmt.Errorf("facility is %d bytes long, max is %d", len(s), maxFacilityLen)
+	}
+	for i, r := range s {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("facility contains control character %q at offset %d", r, i)
+		}
+	}
+	return nil
+}
+
+// checkFacility validates the facility of m in strict mode. The facility
+// is optional, it is only validated when set.
+func checkFacility(m *Message, strict bool) error {
+	if !strict || m.Facility == "" {
+		return nil
+	}
+	return ValidateFacility(m.Facility)
+}
+
+// ErrInvalidJSON is returned in strict mode by the UDP writers configured
+// with NoCompress when an additional field is a json.RawMessage which is not
+// valid UTF-8 encoded JSON, like a binary blob passed by mistake. The error
+// wraps the parse error.
+var ErrInvalidJSON = errors.New("invalid JSON payload")
+
+// errInvalidUTF8 is the parse error of the raw fields which are not UTF-8
+var errInvalidUTF8 = errors.New("invalid UTF-8")
+
+// jsonError wraps the parse error of a raw field, and matches
+// ErrInvalidJSON with errors.Is.
+type jsonError struct {
+	err error
+}
+
+func (e *jsonError) Error() string {
+	return ErrInvalidJSON.Error() + ": " + e.err.Error()
+}
+
+func (e *jsonError) Is(target error) bool {
+	return target == ErrInvalidJSON
+}
+
+func (e *jsonError) Unwrap() error {
+	return e.err
+}
+
+// checkRawExtra validates the json.RawMessage additional fields of m, the
+// only values json.Marshal copies as is. json.Marshal rejects the invalid
+// JSON ones too, but not the invalid UTF-8 ones, and its error doesn't
+// match ErrInvalidJSON.
+func checkRawExtra(m *Message) error {
+	for k, v := range m.Extra {
+		raw, ok := v.(json.RawMessage)
+		if !ok {
+			continue
+		}
+		if !utf8.Valid(raw) {
+			return &ValidationError{Key: k, Err: &jsonError{errInvalidUTF8}}
+		}
+		if err := json.Unmarshal(raw, new(json.RawMessage)); err != nil {
+			return &ValidationError{Key: k, Err: &jsonError{err}}
+		}
+	}
+	return nil
+}
+
+// maxHostnameLen is the maximum length of a hostname, per RFC 1123
+const maxHostnameLen = 255
+
+// sanitizeHostname turns h into an RFC 952/1123 compliant hostname: it is
+// lowercased, underscores are replaced by hyphens, other invalid characters
+// are removed, and the result is truncated to 255 bytes without trailing
+// dots. h is returned as is if nothing valid is left.
+func sanitizeHostname(h string) string {
+	sanitized := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '.':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return unicode.ToLower(r)
+		case r == '_':
+			return '-'
+		default:
+			return -1
+		}
+	}, h)
+
+	if len(sanitized) > maxHostnameLen {
+		sanitized = sanitized[:maxHostnameLen]
+	}
+	sanitized = strings.TrimRight(sanitized, ".")
+	if sanitized == "" {
+		return h
+	}
+	return sanitized
+}
+
+// ExtraValidator checks the additional fields of the messages sent, see
+// UDPWriter.AddExtraValidator.
+type ExtraValidator interface {
+	ValidateExtra(key string, value interface{}) error
+}
+
+// FuncExtraValidator turns a function into an ExtraValidator.
+type FuncExtraValidator func(key string, value interface{}) error
+
+// ValidateExtra calls fn(key, value).
+func (fn FuncExtraValidator) ValidateExtra(key string, value interface{}) error {
+	return fn(key, value)
+}
+
+// ValidationError is returned when an additional field is rejected by an
+// ExtraValidator.
+type ValidationError struct {
+	Key string
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid field %s: %s", e.Key, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// errMissingPrefix is the error of the additional fields without "_" prefix
+var errMissingPrefix = errors.New(`additional field names must start with "_"`)
+
+// SanitizeExtra adds the "_" prefix required by the GELF specification to
+// the additional fields of m missing it. When a field with the prefixed
+// name already exists, it is kept and the unprefixed one is dropped.
+func SanitizeExtra(m *Message) {
+	for k, v := range m.Extra {
+		if strings.HasPrefix(k, "_") {
+			continue
+		}
+		delete(m.Extra, k)
+		if _, exists := m.Extra["_"+k]; !exists {
+			m.Extra["_"+k] = v
+		}
+	}
+}
+
+// checkExtraPrefix checks that the additional fields of m have the "_"
+// prefix. Unless strict is set, a copy of m is returned instead of an
+// error, with the invalid fields renamed like SanitizeExtra does, or
+// dropped if strip is set.
+func checkExtraPrefix(m *Message, strict, strip bool) (*Message, error) {
+	var invalid []string
+	for k := range m.Extra {
+		if !strings.HasPrefix(k, "_") {
+			invalid = append(invalid, k)
+		}
+	}
+	if len(invalid) == 0 {
+		return m, nil
+	}
+	if strict {
+		sort.Strings(invalid)
+		return nil, &ValidationError{Key: invalid[0], Err: errMissingPrefix}
+	}
+
+	fixed := *m
+	fixed.Extra = make(map[string]interface{}, len(m.Extra))
+	for k, v := range m.Extra {
+		fixed.Extra[k] = v
+	}
+	if strip {
+		for _, k := range invalid {
+			delete(fixed.Extra, k)
+		}
+	} else {
+		SanitizeExtra(&fixed)
+	}
+	return &fixed, nil
+}
+
+// validateExtra runs the validators on the additional fields of m, in key
+// order, and returns the first error.
+func validateExtra(m *Message, validators []ExtraValidator) error {
+	if len(validators) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(m.Extra))
+	for k := range m.Extra {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		for _, v := range validators {
+			if err := v.ValidateExtra(k, m.Extra[k]); err != nil {
+				return &ValidationError{Key: k, Err: err}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,310 @@
+package graylog
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestValidateFacility(t *testing.T) {
+	valid := []string{"api", "billing-worker", "app.web_1", strings.Repeat("a", 128)}
+	for _, s := range valid {
+		if err := ValidateFacility(s); err != nil {
+			t.Errorf("ValidateFacility(%q): unexpected error %s", s, err)
+		}
+	}
+
+	invalid := []string{"", strings.Repeat("a", 129), "api\n", "api\x00", "\tapi"}
+	for _, s := range invalid {
+		if err := ValidateFacility(s); err == nil {
+			t.Errorf("ValidateFacility(%q): expected an error", s)
+		}
+	}
+}
+
+func TestStrictModeFacility(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+
+	StrictMode = true
+	defer func() { StrictMode = false }()
+
+	if _, err := NewWriter(r.Addr(), WithFacilityFromEnvOrDefault("GRAYLOG_TEST_UNSET", "bad\nfacility")); err == nil {
+		t.Error("NewWriter should reject an invalid facility in strict mode")
+	}
+
+	w, err := NewWriter(r.Addr())
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	w.(*UDPWriter).Facility = "bad\x00facility"
+	if _, err := w.(*UDPWriter).Write([]byte("test message")); err == nil {
+		t.Error("Write should reject an invalid facility in strict mode")
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %s", err)
+	}
+	defer l.Close()
+	tw, err := NewWriter("tcp://" + l.Addr().String())
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	defer tw.(GELFCloser).Close()
+	hw, err := NewWriter("http://127.0.0.1:12201/gelf")
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	m := Logf(SyslogInformational, "test message")
+	m.Facility = "bad\nfacility"
+	for name, w := range map[string]GELFWriter{"TCP": tw, "HTTP": hw} {
+		if err := w.WriteMessage(m); err == nil || !strings.Contains(err.Error(), "control character") {
+			t.Errorf("%s writer: expected the invalid facility to be rejected, got %v", name, err)
+		}
+	}
+
+	dest := &flakyWriter{}
+	hook := NewGraylogHook(r.Addr(), nil)
+	hook.gelfLogger = dest
+	hook.FacilityFunc = func() string { return "bad\tfacility" }
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	logger.Hooks.Add(hook)
+	logger.Info("invalid facility")
+	if got := dest.messages(); len(got) != 0 {
+		t.Errorf("the hook should not send an invalid facility in strict mode, sent %v", got)
+	}
+}
+
+func FuzzValidateFacility(f *testing.F) {
+	for _, s := range []string{"api", "", "a\nb", "\x00", strings.Repeat("x", 200)} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		err := ValidateFacility(s)
+		hasControl := strings.IndexFunc(s, unicode.IsControl) >= 0
+		if ok := s != "" && len(s) <= maxFacilityLen && !hasControl; ok != (err == nil) {
+			t.Errorf("ValidateFacility(%q) = %v", s, err)
+		}
+	})
+}
+
+func TestStrictModeNoCompress(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+
+	StrictMode = true
+	w, err := NewWriter(r.Addr())
+	StrictMode = false
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	w.(*UDPWriter).CompressionType = NoCompress
+	if err := w.WriteMessage(Logf(SyslogInfoLevel, "valid message")); err != nil {
+		t.Errorf("WriteMessage: %s", err)
+	}
+
+	invalid := []json.RawMessage{json.RawMessage(`{"short_message":`), json.RawMessage("\x1f\x8b binary"), json.RawMessage("{\"a\":\"\xff\"}")}
+	for _, raw := range invalid {
+		m := Logf(SyslogInfoLevel, "raw field").AppendExtra("_raw", raw)
+		if err := w.WriteMessage(m); !errors.Is(err, ErrInvalidJSON) {
+			t.Errorf("WriteMessage(%q): expected ErrInvalidJSON, got %v", raw, err)
+		}
+	}
+	m := Logf(SyslogInfoLevel, "raw field").AppendExtra("_raw", invalid[0])
+	var syntaxErr *json.SyntaxError
+	if err := w.WriteMessage(m); !errors.As(err, &syntaxErr) {
+		t.Errorf("WriteMessage: expected the error to wrap the parse error, got %v", err)
+	}
+
+	m = Logf(SyslogInfoLevel, "raw field").AppendExtra("_raw", json.RawMessage(`{"ok":true}`))
+	if err := w.WriteMessage(m); err != nil {
+		t.Errorf("WriteMessage: %s", err)
+	}
+
+	// compressed payloads are not checked
+	w.(*UDPWriter).CompressionType = CompressGzip
+	m = Logf(SyslogInfoLevel, "raw field").AppendExtra("_raw", invalid[2])
+	if err := w.WriteMessage(m); errors.Is(err, ErrInvalidJSON) {
+		t.Errorf("WriteMessage: expected compressed messages not to be checked, got %v", err)
+	}
+}
+
+func TestSanitizeHostname(t *testing.T) {
+	tests := map[string]string{
+		"web-1":                         "web-1",
+		"Web_Server_1":                  "web-server-1",
+		"api.example.com.":              "api.example.com",
+		"pod name!@#(1)":                "podname1",
+		"café":                          "caf",
+		"!!!":                           "!!!",
+		strings.Repeat("a", 300):        strings.Repeat("a", 255),
+		strings.Repeat("a", 254) + ".b": strings.Repeat("a", 254),
+	}
+	for h, expected := range tests {
+		if got := sanitizeHostname(h); got != expected {
+			t.Errorf("sanitizeHostname(%q): expected %q, got %q", h, expected, got)
+		}
+	}
+}
+
+func TestWithRawHostname(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+
+	host, _ := os.Hostname()
+	w, err := NewWriter(r.Addr(), WithRawHostname())
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	if got := w.(*UDPWriter).hostname; got != host {
+		t.Errorf("hostname: expected %q, got %q", host, got)
+	}
+
+	w, err = NewWriter(r.Addr())
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	if got := w.(*UDPWriter).hostname; got != sanitizeHostname(host) {
+		t.Errorf("hostname: expected %q, got %q", sanitizeHostname(host), got)
+	}
+}
+
+func TestWithHostname(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+
+	w, err := NewWriter(r.Addr(), WithHostname("checkout-api"))
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	if _, err := w.(*UDPWriter).Write([]byte("overridden host")); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	msg, err := r.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+	if msg.Host != "checkout-api" {
+		t.Errorf("host: expected checkout-api, got %q", msg.Host)
+	}
+
+	for _, h := range []string{"", "checkout api", "checkout\tapi"} {
+		if _, err := NewWriter(r.Addr(), WithHostname(h)); err == nil {
+			t.Errorf("WithHostname(%q): expected an error", h)
+		}
+	}
+}
+
+func TestAddExtraValidator(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+	w, err := NewWriter(r.Addr())
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+
+	errUnknownEnv := errors.New("unknown environment")
+	w.(*UDPWriter).AddExtraValidator(FuncExtraValidator(func(key string, value interface{}) error {
+		if key == "_env" && value != "dev" && value != "staging" && value != "prod" {
+			return errUnknownEnv
+		}
+		return nil
+	}))
+
+	err = w.WriteMessage(Logf(SyslogInformational, "rejected").AppendExtra("env", "qa"))
+	var verr *ValidationError
+	if !errors.As(err, &verr) || verr.Key != "_env" || !errors.Is(err, errUnknownEnv) {
+		t.Fatalf("WriteMessage: expected a validation error on _env, got %v", err)
+	}
+
+	if err := w.WriteMessage(Logf(SyslogInformational, "accepted").AppendExtra("env", "prod")); err != nil {
+		t.Fatalf("WriteMessage: %s", err)
+	}
+	msg, err := r.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+	if msg.Short != "accepted" {
+		t.Errorf("expected only the valid message to be sent, got %q", msg.Short)
+	}
+}
+
+func TestExtraPrefix(t *testing.T) {
+	r, err := NewReader("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	defer r.conn.Close()
+	w, err := NewWriter(r.Addr())
+	if err != nil {
+		t.Fatalf("NewWriter: %s", err)
+	}
+	uw := w.(*UDPWriter)
+
+	m := Logf(SyslogInformational, "unprefixed")
+	m.Extra["user"] = "alice"
+	m.Extra["_env"] = "prod"
+
+	for _, tc := range []struct {
+		strip    bool
+		expected map[string]interface{}
+	}{
+		{false, map[string]interface{}{"_user": "alice", "_env": "prod"}},
+		{true, map[string]interface{}{"_env": "prod"}},
+	} {
+		uw.StripInvalidExtra = tc.strip
+		if err := uw.WriteMessage(m); err != nil {
+			t.Fatalf("WriteMessage: %s", err)
+		}
+		msg, err := r.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage: %s", err)
+		}
+		if !reflect.DeepEqual(msg.Extra, tc.expected) {
+			t.Errorf("Extra: expected %v, got %v", tc.expected, msg.Extra)
+		}
+	}
+	if _, ok := m.Extra["user"]; !ok {
+		t.Error("WriteMessage should not modify the message")
+	}
+
+	uw.StrictFieldNames = true
+	var verr *ValidationError
+	if err := uw.WriteMessage(m); !errors.As(err, &verr) || verr.Key != "user" {
+		t.Errorf("WriteMessage: expected a validation error on user, got %v", err)
+	}
+}
+
+func TestSanitizeExtra(t *testing.T) {
+	m := &Message{Extra: map[string]interface{}{"user": "alice", "env": "qa", "_env": "prod"}}
+	SanitizeExtra(m)
+
+	expected := map[string]interface{}{"_user": "alice", "_env": "prod"}
+	if !reflect.DeepEqual(m.Extra, expected) {
+		t.Errorf("Extra: expected %v, got %v", expected, m.Extra)
+	}
+}
@@ -0,0 +1,65 @@
+package zap
+
+import (
+	"strings"
+
+	graylog "github.com/gemnasium/logrus-graylog-hook/v3"
+	"go.uber.org/zap/zapcore"
+)
+
+// gelfCore is a zapcore.Core sending entries to a GELF writer
+type gelfCore struct {
+	zapcore.LevelEnabler
+	w      graylog.GELFWriter
+	enc    zapcore.Encoder
+	fields []zapcore.Field
+}
+
+// NewGELFCore creates a zap core sending the entries enabled by level to w,
+// converted with MessageFromZapEntry. When enc isn't nil, the full message
+// is set to the entry encoded by enc, like it would be logged by
+// zapcore.NewCore.
+func NewGELFCore(w graylog.GELFWriter, enc zapcore.Encoder, level zapcore.LevelEnabler) zapcore.Core {
+	return &gelfCore{LevelEnabler: level, w: w, enc: enc}
+}
+
+// With returns a copy of the core adding fields to every message.
+func (c *gelfCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.fields = append(c.fields[:len(c.fields):len(c.fields)], fields...)
+	return &clone
+}
+
+func (c *gelfCore) Check(entry zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return ce.AddCore(entry, c)
+	}
+	return ce
+}
+
+func (c *gelfCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	fields = append(c.fields[:len(c.fields):len(c.fields)], fields...)
+	m := MessageFromZapEntry(entry, fields)
+
+	if c.enc != nil {
+		buf, err := c.enc.EncodeEntry(entry, fields)
+		if err != nil {
+			return err
+		}
+		m.Full = strings.TrimSpace(buf.String())
+		buf.Free()
+	}
+
+	return c.w.WriteMessage(m)
+}
+
+// Sync flushes the writer when it has a Flush method.
+func (c *gelfCore) Sync() error {
+	switch w := c.w.(type) {
+	case interface{ Flush() error }:
+		return w.Flush()
+	case interface{ Flush() }:
+		w.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package zap
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	graylog "github.com/gemnasium/logrus-graylog-hook/v3"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// recordingWriter keeps the messages written, and counts flushes
+type recordingWriter struct {
+	mu       sync.Mutex
+	messages []*graylog.Message
+	flushes  int
+}
+
+func (w *recordingWriter) WriteMessage(m *graylog.Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.messages = append(w.messages, m)
+	return nil
+}
+
+func (w *recordingWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.flushes++
+}
+
+func TestNewGELFCore(t *testing.T) {
+	w := &recordingWriter{}
+	enc := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	logger := zap.New(NewGELFCore(w, enc, zapcore.InfoLevel)).With(zap.String("service", "billing"))
+
+	logger.Debug("ignored")
+	logger.Info("payment accepted", zap.Int("amount", 1200))
+	if err := logger.Sync(); err != nil {
+		t.Fatalf("Sync: %s", err)
+	}
+
+	if len(w.messages) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(w.messages))
+	}
+	m := w.messages[0]
+	if m.Short != "payment accepted" || m.Level != 6 {
+		t.Errorf("message: expected info payment accepted, got %d %q", m.Level, m.Short)
+	}
+	if m.Extra["_service"] != "billing" || m.Extra["_amount"] != int64(1200) {
+		t.Errorf("Extra: expected _service and _amount, got %v", m.Extra)
+	}
+	if !strings.Contains(m.Full, `"service":"billing"`) {
+		t.Errorf("Full: expected the JSON encoded entry, got %q", m.Full)
+	}
+	if w.flushes != 1 {
+		t.Errorf("Sync: expected 1 flush, got %d", w.flushes)
+	}
+}
+
+func TestNewGELFCoreWithoutEncoder(t *testing.T) {
+	w := &recordingWriter{}
+	core := NewGELFCore(w, nil, zapcore.DebugLevel)
+	parent := core.With([]zapcore.Field{zap.String("parent", "yes")})
+	child := parent.With([]zapcore.Field{zap.String("child", "yes")})
+
+	zap.New(parent).Info("from parent")
+	zap.New(child).Info("from child")
+
+	if _, ok := w.messages[0].Extra["_child"]; ok {
+		t.Error("With should not change the parent core")
+	}
+	if w.messages[1].Extra["_parent"] != "yes" || w.messages[1].Extra["_child"] != "yes" {
+		t.Errorf("Extra: expected _parent and _child, got %v", w.messages[1].Extra)
+	}
+	if w.messages[1].Full != "" {
+		t.Errorf("Full: expected no full message without encoder, got %q", w.messages[1].Full)
+	}
+}
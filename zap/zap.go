@@ -0,0 +1,81 @@
+// Package zap converts go.uber.org/zap log entries into GELF messages.
+package zap
+
+import (
+	"os"
+	"strings"
+	"sync"
+
+	graylog "github.com/gemnasium/logrus-graylog-hook/v3"
+	"github.com/sirupsen/logrus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+var (
+	hostnameOnce sync.Once
+	hostname     string
+)
+
+func cachedHostname() string {
+	hostnameOnce.Do(func() {
+		var err error
+		if hostname, err = os.Hostname(); err != nil {
+			hostname = "localhost"
+		}
+	})
+	return hostname
+}
+
+// MessageFromZapEntry builds a GELF message from a zap entry and its
+// fields. The entry message becomes the short message and its stack trace,
+// if any, the full message. Fields are added as additional fields.
+func MessageFromZapEntry(entry zapcore.Entry, fields []zap.Field) *graylog.Message {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+
+	extra := make(map[string]interface{}, len(enc.Fields)+1)
+	for k, v := range enc.Fields {
+		extra["_"+strings.TrimPrefix(k, "_")] = v
+	}
+	if entry.LoggerName != "" {
+		extra["_logger"] = entry.LoggerName
+	}
+
+	m := &graylog.Message{
+		Version:  graylog.GELFVersion,
+		Host:     cachedHostname(),
+		Short:    entry.Message,
+		Full:     entry.Stack,
+		TimeUnix: float64(entry.Time.UnixNano()/1000000) / 1000.,
+		Level:    zapLevelToSyslog(entry.Level),
+		Extra:    extra,
+	}
+	if entry.Caller.Defined {
+		m.File = entry.Caller.File
+		m.Line = entry.Caller.Line
+	}
+
+	return m
+}
+
+// zapLevelToSyslog maps level to the closest logrus level, and uses the
+// mapping of the hook, graylog.SyslogLevel.
+func zapLevelToSyslog(level zapcore.Level) int32 {
+	switch level {
+	case zapcore.PanicLevel:
+		return graylog.SyslogLevel(logrus.PanicLevel)
+	case zapcore.FatalLevel, zapcore.DPanicLevel:
+		return graylog.SyslogLevel(logrus.FatalLevel)
+	case zapcore.ErrorLevel:
+		return graylog.SyslogLevel(logrus.ErrorLevel)
+	case zapcore.WarnLevel:
+		return graylog.SyslogLevel(logrus.WarnLevel)
+	case zapcore.InfoLevel:
+		return graylog.SyslogLevel(logrus.InfoLevel)
+	default:
+		return graylog.SyslogLevel(logrus.DebugLevel)
+	}
+}
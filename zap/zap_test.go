@@ -0,0 +1,78 @@
+package zap
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestMessageFromZapEntry(t *testing.T) {
+	now := time.Unix(1577836800, 0)
+	entry := zapcore.Entry{
+		Level:      zapcore.WarnLevel,
+		Time:       now,
+		LoggerName: "billing",
+		Message:    "payment declined",
+		Caller:     zapcore.NewEntryCaller(0, "billing.go", 42, true),
+		Stack:      "goroutine 1 [running]:",
+	}
+	fields := []zap.Field{
+		zap.String("user", "alice"),
+		zap.Int64("amount", 1200),
+		zap.Bool("retry", true),
+		zap.Time("at", now),
+		zap.Duration("elapsed", time.Second),
+	}
+
+	m := MessageFromZapEntry(entry, fields)
+
+	if m.Short != "payment declined" {
+		t.Errorf("Short: expected %q, got %q", "payment declined", m.Short)
+	}
+	if m.Full != entry.Stack {
+		t.Errorf("Full: expected %q, got %q", entry.Stack, m.Full)
+	}
+	if m.Level != 4 {
+		t.Errorf("Level: expected 4, got %d", m.Level)
+	}
+	if host, _ := os.Hostname(); m.Host != host {
+		t.Errorf("Host: expected %s, got %s", host, m.Host)
+	}
+	if m.File != "billing.go" || m.Line != 42 {
+		t.Errorf("caller: expected billing.go:42, got %s:%d", m.File, m.Line)
+	}
+
+	expected := map[string]interface{}{
+		"_user":    "alice",
+		"_amount":  int64(1200),
+		"_retry":   true,
+		"_at":      now,
+		"_elapsed": time.Second,
+		"_logger":  "billing",
+	}
+	for k, v := range expected {
+		if m.Extra[k] != v {
+			t.Errorf("Extra[%s]: expected %#v, got %#v", k, v, m.Extra[k])
+		}
+	}
+}
+
+func TestZapLevelToSyslog(t *testing.T) {
+	levels := map[zapcore.Level]int32{
+		zapcore.DebugLevel:  7,
+		zapcore.InfoLevel:   6,
+		zapcore.WarnLevel:   4,
+		zapcore.ErrorLevel:  3,
+		zapcore.DPanicLevel: 2,
+		zapcore.PanicLevel:  1,
+		zapcore.FatalLevel:  2,
+	}
+	for zl, expected := range levels {
+		if got := zapLevelToSyslog(zl); got != expected {
+			t.Errorf("zapLevelToSyslog(%s): expected %d, got %d", zl, expected, got)
+		}
+	}
+}